@@ -1,3 +1,8 @@
 module github.com/SpongeData-cz/parsemail
 
-go 1.16
\ No newline at end of file
+go 1.16
+
+require (
+	golang.org/x/net v0.0.0-20220722155237-a158d28d115b
+	golang.org/x/text v0.3.8
+)
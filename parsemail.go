@@ -1,72 +1,507 @@
 package parsemail
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"math"
 	"mime"
 	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
 	"net/mail"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	htmlcharset "golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 const contentTypeMultipartMixed = "multipart/mixed"
 const contentTypeMultipartAlternative = "multipart/alternative"
 const contentTypeMultipartRelated = "multipart/related"
+const contentTypeMultipartAppleDouble = "multipart/appledouble"
+const contentTypeApplicationAppleFile = "application/applefile"
+const contentTypeTextVCard = "text/vcard"
+const contentTypeTextXVCard = "text/x-vcard"
 const contentTypeTextHtml = "text/html"
 const contentTypeTextPlain = "text/plain"
+const contentTypeApplicationPkcs7Mime = "application/pkcs7-mime"
+const contentTypeApplicationXPkcs7Mime = "application/x-pkcs7-mime"
+const contentTypeMessageRFC822 = "message/rfc822"
+const contentTypeMultipartReport = "multipart/report"
+const contentTypeMultipartSigned = "multipart/signed"
+const contentTypeMultipartEncrypted = "multipart/encrypted"
+const contentTypeMessageDeliveryStatus = "message/delivery-status"
+const contentTypeTextRFC822Headers = "text/rfc822-headers"
+
+// defaultMaxDepth is the multipart nesting limit used when Options.MaxDepth is not set
+const defaultMaxDepth = 50
+
+// maxMessageNesting limits how deep message/rfc822 attachments are parsed recursively into Attachment.Message
+const maxMessageNesting = 10
+
+const smimeTypeEnvelopedData = "enveloped-data"
+
+// SkipPart can be returned by the Email.Walk and Part.Walk callbacks to skip the contents of the visited part
+var SkipPart = errors.New("skip this part")
+
+// SkipAll can be returned by the Email.Walk and Part.Walk callbacks to stop the walk without an error
+var SkipAll = errors.New("skip all remaining parts")
+
+// Values of the RFC2156 Sensitivity header as normalized in Email.Sensitivity
+const (
+	SensitivityPersonal            = "Personal"
+	SensitivityPrivate             = "Private"
+	SensitivityCompanyConfidential = "Company-Confidential"
+)
+
+// ErrHeadersTooLarge is returned when the header block of a message exceeds Options.MaxHeaderSize
+var ErrHeadersTooLarge = errors.New("headers too large")
+
+// ErrSizeLimitExceeded is returned when a body or attachment exceeds Options.MaxBodySize or Options.MaxAttachmentSize
+var ErrSizeLimitExceeded = errors.New("size limit exceeded")
+
+// ErrMaxDepthExceeded is returned when multipart bodies are nested deeper than Options.MaxDepth
+var ErrMaxDepthExceeded = errors.New("multipart nesting too deep")
+
+// ErrMalformedDate is reported in Email.Warnings by Options.Strict for a Date header which cannot be parsed
+var ErrMalformedDate = errors.New("malformed Date header")
+
+// ErrMissingMIMEVersion is reported in Email.Warnings by Options.Strict for multipart or encoded messages without
+// the MIME-Version header required by RFC2045
+var ErrMissingMIMEVersion = errors.New("missing MIME-Version header")
+
+// ErrQuotedPrintableTruncated is reported in Email.Warnings for a quoted-printable part ending with a soft line
+// break, which suggests it was cut short by an unescaped line matching the multipart boundary
+var ErrQuotedPrintableTruncated = errors.New("quoted-printable part ends with a soft line break")
+
+// ErrMissingContentType is returned by Options.StrictErrors for a multipart part without a Content-Type header
+var ErrMissingContentType = errors.New("missing Content-Type header")
+
+// ErrInvalidTransferEncoding is returned by Options.StrictErrors for a Content-Transfer-Encoding not defined by
+// RFC2045
+var ErrInvalidTransferEncoding = errors.New("invalid Content-Transfer-Encoding")
+
+// ErrBareLineFeed is returned by Options.StrictErrors for a message with lines ending in LF rather than CRLF
+var ErrBareLineFeed = errors.New("line not terminated by CRLF")
+
+// ErrDuplicateHeader is returned by Options.StrictErrors for a header which RFC5322 allows only once, e.g. Date,
+// occurring several times
+var ErrDuplicateHeader = errors.New("duplicate header")
+
+// ErrConflictingOptions is returned when Options.Lenient and Options.StrictErrors are both set
+var ErrConflictingOptions = errors.New("options Lenient and StrictErrors cannot be combined")
+
+// singletonHeaders may occur at most once in a message as per RFC5322 section 3.6
+var singletonHeaders = []string{
+	"Date", "From", "Sender", "Reply-To", "To", "Cc", "Bcc", "Message-Id", "In-Reply-To", "References", "Subject",
+}
+
+// maxReplyReferences caps the number of message ids in References built by Email.BuildReplyHeaders
+const maxReplyReferences = 20
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+var replyAttributionRegexp = regexp.MustCompile(`(?i)^on\b.*\bwrote:$`)
+var messageIdRegexp = regexp.MustCompile(`<([^<>]*)>`)
+var uuencodeBeginRegexp = regexp.MustCompile(`^begin [0-7]{3,4} (\S.*)$`)
 
 // Parse an email message read from io.Reader into parsemail.Email struct
 func Parse(r io.Reader) (email Email, err error) {
+	return ParseContext(context.Background(), r)
+}
+
+// ParseContext parses an email message read from io.Reader into parsemail.Email struct like Parse. Once ctx is
+// done, the parse is aborted with ctx.Err() before the next read from r or the next MIME part.
+func ParseContext(ctx context.Context, r io.Reader) (email Email, err error) {
+	return parseWithContext(ctx, r, Options{})
+}
+
+// ParseBytes parses an email message held in b into parsemail.Email struct
+func ParseBytes(b []byte) (email Email, err error) {
+	return Parse(bytes.NewReader(b))
+}
+
+// ParseFile parses the email message stored in the file at path (e.g. an .eml file) into parsemail.Email struct
+func ParseFile(path string) (email Email, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// ParseWithOptions parses an email message read from io.Reader into parsemail.Email struct using the given Options
+func ParseWithOptions(r io.Reader, opts Options) (email Email, err error) {
+	return parseWithContext(context.Background(), r, opts)
+}
+
+func parseWithContext(ctx context.Context, r io.Reader, opts Options) (email Email, err error) {
+	if opts.Lenient && opts.StrictErrors {
+		err = ErrConflictingOptions
+		return
+	}
+
+	p := parser{opts: opts, ctx: ctx}
+	if ra, ok := r.(io.ReaderAt); ok && opts.LazyAttachments {
+		p.source = ra
+		if s, ok := r.(io.Seeker); ok {
+			p.sourceStart, err = s.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	var raw *bytes.Buffer
+	if opts.KeepRaw {
+		raw = &bytes.Buffer{}
+		r = io.TeeReader(r, raw)
+	}
+
+	// contexts which can never be done, like context.Background, need no checks on every read
+	if ctx.Done() != nil {
+		r = contextReader{ctx: ctx, r: r}
+	}
+
+	email, err = p.parse(r)
+	if err != nil || raw == nil {
+		return
+	}
+
+	// the parse stops at the closing boundary, the epilogue is part of the message as well
+	if _, err = io.Copy(io.Discard, r); err != nil {
+		return
+	}
+
+	email.Raw = raw.Bytes()
+
+	return
+}
+
+func (p *parser) parse(r io.Reader) (email Email, err error) {
+	var lineEndings *lineEndingReader
+	if p.opts.StrictErrors {
+		lineEndings = &lineEndingReader{r: r}
+		r = lineEndings
+	}
+
+	if p.opts.SMTPDotStuffed {
+		r = unstuffDots(r)
+	}
+
+	r = stripBOM(r)
+
+	if p.opts.MaxHeaderSize > 0 {
+		r, err = limitHeader(r, p.opts.MaxHeaderSize)
+		if err != nil {
+			return
+		}
+	}
+
 	msg, err := mail.ReadMessage(r)
 	if err != nil {
 		return
 	}
 
-	email, err = createEmailFromHeader(msg.Header)
+	email, err = p.createEmailFromHeader(msg.Header)
 	if err != nil {
 		return
 	}
 
+	email.charsetEncoder = p.opts.CharsetEncoder
+	email.Structure = &Part{
+		ContentType: mediaType(msg.Header.Get("Content-Type")),
+		Header:      cloneMIMEHeader(textproto.MIMEHeader(msg.Header)),
+	}
+	p.structure = email.Structure
 	email.ContentType = msg.Header.Get("Content-Type")
+	email.TransferEncoding = strings.ToLower(strings.TrimSpace(msg.Header.Get("Content-Transfer-Encoding")))
+	email.MIMEVersion = strings.TrimSpace(msg.Header.Get("MIME-Version"))
+	email.ContentBase = strings.Trim(strings.Join(strings.Fields(msg.Header.Get("Content-Base")), ""), `"`)
 	contentType, params, err := parseContentType(email.ContentType)
 	if err != nil {
-		return
+		// with Options.Lenient the body is kept undecoded as Content
+		err = p.tolerate(err)
+		if err != nil {
+			return
+		}
+	}
+
+	if p.strict() && email.MIMEVersion == "" &&
+		(strings.HasPrefix(contentType, "multipart/") || email.TransferEncoding == "base64" || email.TransferEncoding == "quoted-printable") {
+		err = p.violation(ErrMissingMIMEVersion)
+		if err != nil {
+			return
+		}
+	}
+
+	if date := msg.Header.Get("Date"); p.strict() && email.Date.IsZero() && strings.TrimSpace(date) != "" {
+		err = p.violation(fmt.Errorf("%w: %s", ErrMalformedDate, date))
+		if err != nil {
+			return
+		}
+	}
+
+	if p.opts.StrictErrors {
+		for _, name := range singletonHeaders {
+			if len(msg.Header[name]) > 1 {
+				err = fmt.Errorf("%w: %s", ErrDuplicateHeader, name)
+				return
+			}
+		}
 	}
 
 	switch contentType {
 	case contentTypeMultipartMixed:
-		email.TextBody, email.HTMLBody, email.Attachments, email.EmbeddedFiles, err = parseMultipartMixed(msg.Body, params["boundary"])
+		err = p.parseMultipartMixed(&email, msg.Body, p.boundary(params))
 	case contentTypeMultipartAlternative:
-		email.TextBody, email.HTMLBody, email.EmbeddedFiles, err = parseMultipartAlternative(msg.Body, params["boundary"])
+		err = p.parseMultipartAlternative(&email, msg.Body, p.boundary(params))
 	case contentTypeMultipartRelated:
-		email.TextBody, email.HTMLBody, email.EmbeddedFiles, err = parseMultipartRelated(msg.Body, params["boundary"])
-	case contentTypeTextPlain:
-		message, _ := io.ReadAll(msg.Body)
-		email.TextBody = strings.TrimSuffix(string(message[:]), "\n")
-	case contentTypeTextHtml:
-		message, _ := io.ReadAll(msg.Body)
-		email.HTMLBody = strings.TrimSuffix(string(message[:]), "\n")
+		err = p.parseMultipartRelated(&email, msg.Body, p.boundary(params))
+	case contentTypeMultipartAppleDouble:
+		err = p.parseMultipartAppleDouble(&email, msg.Body, p.boundary(params))
+	case contentTypeMultipartReport:
+		err = p.parseMultipartReport(&email, msg.Body, p.boundary(params))
+	case contentTypeMultipartSigned:
+		err = p.parseMultipartSigned(&email, msg.Body, p.boundary(params), params)
+	case contentTypeMultipartEncrypted:
+		err = p.parseMultipartEncrypted(&email, msg.Body, p.boundary(params), params["protocol"])
+	case contentTypeTextPlain, contentTypeTextHtml:
+		err = p.parseTextPart(&email, email.Structure, msg.Body, msg.Header.Get("Content-Transfer-Encoding"), contentType, params)
+	case contentTypeApplicationPkcs7Mime, contentTypeApplicationXPkcs7Mime:
+		err = p.parseSMIME(&email, email.Structure, msg.Body, msg.Header.Get("Content-Transfer-Encoding"), params["smime-type"])
 	default:
-		email.Content, err = decodeContent(msg.Body, msg.Header.Get("Content-Transfer-Encoding"))
+		email.Content, err = p.decodeContent(msg.Body, msg.Header.Get("Content-Transfer-Encoding"), p.opts.MaxBodySize, email.Structure)
+		if err == nil && p.opts.SniffContentType && contentType == "application/octet-stream" {
+			email.DetectedContentType, err = sniffContentType(&email.Content)
+		}
+	}
+
+	err = p.tolerate(err)
+	if err == nil && lineEndings != nil {
+		// the parse stops at the closing boundary, the line endings of the epilogue count as well
+		_, err = io.Copy(io.Discard, msg.Body)
+		if err == nil && lineEndings.bareLF {
+			err = ErrBareLineFeed
+		}
 	}
 
+	email.Truncated = p.truncated
+	email.Warnings = p.warnings
+
 	return
 }
 
-func createEmailFromHeader(header mail.Header) (email Email, err error) {
-	hp := headerParser{header: &header}
+// FindAttachment walks the MIME tree of an email message read from io.Reader and returns the first attachment
+// for which match returns true. Parts following the matching attachment are neither read nor decoded.
+// When no attachment matches, nil is returned without an error.
+func FindAttachment(r io.Reader, match func(PartMeta) bool) (*Attachment, error) {
+	p := parser{}
+
+	var at *Attachment
+	_, err := p.findAttachmentInMessage(r, match, func(part *multipart.Part) error {
+		decoded, err := p.decodeAttachment(part)
+		if err != nil {
+			return err
+		}
+
+		at = &decoded
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return at, nil
+}
+
+// ExtractAttachment streams the decoded data of the first attachment of an email message read from io.Reader
+// whose filename matches name case-insensitively, like Email.AttachmentByName, to w. The attachment is
+// transfer-decoded on the fly without being buffered and parts following it are not read. found reports whether
+// the attachment exists.
+func ExtractAttachment(r io.Reader, name string, w io.Writer) (found bool, err error) {
+	p := parser{}
+
+	match := func(meta PartMeta) bool {
+		return strings.EqualFold(meta.Filename, name)
+	}
+
+	return p.findAttachmentInMessage(r, match, func(part *multipart.Part) error {
+		decoder, err := p.newDecoder(part, part.Header.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(w, decoder)
+		return err
+	})
+}
+
+// findAttachmentInMessage calls found with the first attachment of the message for which match returns true
+func (p *parser) findAttachmentInMessage(r io.Reader, match func(PartMeta) bool, found func(*multipart.Part) error) (bool, error) {
+	msg, err := mail.ReadMessage(stripBOM(r))
+	if err != nil {
+		return false, err
+	}
+
+	contentType, params, err := parseContentType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return false, err
+	}
+
+	if !strings.HasPrefix(contentType, "multipart/") {
+		return false, nil
+	}
+
+	return p.findAttachment(msg.Body, p.boundary(params), 0, match, found)
+}
+
+// EstimateSize cheaply estimates the decoded size of an email message read from io.Reader, e.g. for quota checks
+// before a full parse. Part headers are read, but bodies are only counted, not decoded. The size of base64 encoded
+// bodies is estimated as 3/4 of their encoded size, other bodies are counted as they are.
+func EstimateSize(r io.Reader) (int64, error) {
+	msg, err := mail.ReadMessage(stripBOM(r))
+	if err != nil {
+		return 0, err
+	}
+
+	contentType, params, err := parseContentType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return 0, err
+	}
+
+	return estimateSize(msg.Body, contentType, params, msg.Header.Get("Content-Transfer-Encoding"), 0)
+}
+
+func estimateSize(body io.Reader, contentType string, params map[string]string, encoding string, depth int) (int64, error) {
+	if !strings.HasPrefix(contentType, "multipart/") {
+		n, err := io.Copy(io.Discard, body)
+		if err != nil {
+			return 0, err
+		}
+
+		if strings.ToLower(strings.TrimSpace(encoding)) == "base64" {
+			return n * 3 / 4, nil
+		}
+
+		return n, nil
+	}
+
+	if depth >= defaultMaxDepth {
+		return 0, ErrMaxDepthExceeded
+	}
+
+	body, empty := isEmptyBody(body, params["boundary"])
+	if empty {
+		return 0, nil
+	}
+
+	var size int64
+	pmr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, pmrErr := pmr.NextPart()
+		if pmrErr == io.EOF {
+			return size, nil
+		} else if pmrErr != nil {
+			return 0, pmrErr
+		}
+
+		partContentType, partParams, mimeErr := parseContentType(part.Header.Get("Content-Type"))
+		if mimeErr != nil {
+			return 0, mimeErr
+		}
+
+		n, err := estimateSize(part, partContentType, partParams, part.Header.Get("Content-Transfer-Encoding"), depth+1)
+		if err != nil {
+			return 0, err
+		}
+
+		size += n
+	}
+}
+
+func (p *parser) findAttachment(msg io.Reader, boundary string, depth int, match func(PartMeta) bool, found func(*multipart.Part) error) (bool, error) {
+	if depth >= p.maxDepth() {
+		return false, ErrMaxDepthExceeded
+	}
+
+	msg, empty := isEmptyBody(msg, boundary)
+	if empty {
+		return false, nil
+	}
+
+	pmr := multipart.NewReader(msg, boundary)
+	for {
+		part, pmrErr := pmr.NextPart()
+		if pmrErr == io.EOF {
+			return false, nil
+		} else if pmrErr != nil {
+			return false, pmrErr
+		}
+
+		contentType, params, mimeErr := parseContentType(part.Header.Get("Content-Type"))
+		if mimeErr != nil {
+			return false, mimeErr
+		}
+
+		if strings.HasPrefix(contentType, "multipart/") {
+			ok, err := p.findAttachment(part, p.boundary(params), depth+1, match, found)
+			if err != nil || ok {
+				return ok, err
+			}
+
+			continue
+		}
+
+		if !isAttachment(part) {
+			continue
+		}
+
+		meta := PartMeta{
+			Filename:    p.fileName(part),
+			ContentType: contentType,
+			Header:      part.Header,
+		}
+
+		if match(meta) {
+			return true, found(part)
+		}
+	}
+}
+
+func (p *parser) createEmailFromHeader(header mail.Header) (email Email, err error) {
+	hp := headerParser{header: &header, addressParser: &mail.AddressParser{WordDecoder: p.wordDecoder()}}
 
-	email.Subject = decodeMimeSentence(header.Get("Subject"))
+	email.Subject = p.decodeMimeSentence(header.Get("Subject"))
 	email.From = hp.parseAddressList(header.Get("From"))
 	email.Sender = hp.parseAddress(header.Get("Sender"))
 	email.ReplyTo = hp.parseAddressList(header.Get("Reply-To"))
 	email.To = hp.parseAddressList(header.Get("To"))
 	email.Cc = hp.parseAddressList(header.Get("Cc"))
 	email.Bcc = hp.parseAddressList(header.Get("Bcc"))
-	email.Date = hp.parseTime(header.Get("Date"))
+	email.Date = parseOptionalTime(header.Get("Date"))
 	email.ResentFrom = hp.parseAddressList(header.Get("Resent-From"))
 	email.ResentSender = hp.parseAddress(header.Get("Resent-Sender"))
 	email.ResentTo = hp.parseAddressList(header.Get("Resent-To"))
@@ -76,441 +511,4169 @@ func createEmailFromHeader(header mail.Header) (email Email, err error) {
 	email.MessageID = hp.parseMessageId(header.Get("Message-ID"))
 	email.InReplyTo = hp.parseMessageIdList(header.Get("In-Reply-To"))
 	email.References = hp.parseMessageIdList(header.Get("References"))
-	email.ResentDate = hp.parseTime(header.Get("Resent-Date"))
+	email.ResentDate = parseOptionalTime(header.Get("Resent-Date"))
+	email.Sensitivity = parseSensitivity(header.Get("Sensitivity"))
+	email.Expires = parseOptionalTime(header.Get("Expires"))
+	email.ReplyBy = parseOptionalTime(header.Get("Reply-By"))
+	email.SpamStatus = parseSpamStatus(header.Get("X-Spam-Status"), header.Get("X-Spam-Score"))
+	email.Received = parseReceivedHeaders(header["Received"])
+	email.ListID, email.ListDescription = parseListID(header.Get("List-Id"))
+	email.ListPost = parseListURIs(header.Get("List-Post"))
+	email.ListHelp = parseListURIs(header.Get("List-Help"))
+	email.ListArchive = parseListURIs(header.Get("List-Archive"))
+	email.ListSubscribe = parseListURIs(header.Get("List-Subscribe"))
+	email.ListUnsubscribe = parseListURIs(header.Get("List-Unsubscribe"))
+	email.ListOwner = parseListURIs(header.Get("List-Owner"))
+	email.ListUnsubscribePost = strings.TrimSpace(header.Get("List-Unsubscribe-Post"))
+
+	if hp.err != nil {
+		err = hp.err
+		return
+	}
+
+	//decode whole header for easier access to extra fields
+	//todo: should we decode? aren't only standard fields mime encoded?
+	email.Header, err = p.decodeHeaderMime(header)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// parseSensitivity normalizes the RFC2156 Sensitivity header to one of the Sensitivity constants. Unknown values
+// are returned with whitespace removed, a missing header yields an empty string.
+func parseSensitivity(s string) string {
+	s = strings.Join(strings.Fields(s), "")
+	for _, known := range []string{SensitivityPersonal, SensitivityPrivate, SensitivityCompanyConfidential} {
+		if strings.EqualFold(s, known) {
+			return known
+		}
+	}
+
+	return s
+}
+
+// parseReceivedHeaders parses the Received trace headers into hops, keeping their order
+func parseReceivedHeaders(values []string) (hops []ReceivedHeader) {
+	for _, v := range values {
+		hops = append(hops, parseReceived(v))
+	}
+
+	return
+}
+
+// parseReceived extracts the clauses of a RFC5321 Received header, e.g. "from a.example (a.example [192.0.2.1])
+// by b.example with ESMTPS id 4F2; Fri, 21 Nov 1997 09:55:06 -0600". The header is notoriously irregular, so
+// this is best effort: the first word following each clause keyword is taken, comments are skipped and the
+// date following the last semicolon is parsed if possible.
+func parseReceived(value string) ReceivedHeader {
+	rh := ReceivedHeader{Raw: value}
+
+	clauses := value
+	if i := strings.LastIndex(value, ";"); i >= 0 {
+		clauses = value[:i]
+		rh.Date = parseOptionalTime(value[i+1:])
+	}
+
+	words := strings.Fields(stripComments(clauses))
+	for i := 0; i+1 < len(words); i++ {
+		var field *string
+		switch strings.ToLower(words[i]) {
+		case "from":
+			field = &rh.From
+		case "by":
+			field = &rh.By
+		case "with":
+			field = &rh.With
+		case "id":
+			field = &rh.ID
+		case "for":
+			field = &rh.For
+		default:
+			continue
+		}
+
+		if *field == "" {
+			*field = strings.Trim(words[i+1], "<>")
+			i++
+		}
+	}
+
+	return rh
+}
+
+// stripComments replaces the possibly nested parenthesized comments of a header value with spaces
+func stripComments(s string) string {
+	var b strings.Builder
+	depth := 0
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case depth > 0 && r == '\\':
+			escaped = true
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+			if depth == 0 {
+				b.WriteByte(' ')
+			}
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// parseSpamStatus parses the SpamAssassin X-Spam-Status header, e.g. "Yes, score=7.2 required=5.0 tests=A,B".
+// The score falls back to the X-Spam-Score header. Without either header nil is returned.
+func parseSpamStatus(status, score string) *SpamStatus {
+	status = strings.TrimSpace(status)
+	score = strings.TrimSpace(score)
+	if status == "" && score == "" {
+		return nil
+	}
+
+	ss := &SpamStatus{}
+	ss.Score, _ = strconv.ParseFloat(score, 64)
+
+	fields := strings.Fields(status)
+	if len(fields) > 0 {
+		ss.IsSpam = strings.EqualFold(strings.TrimSuffix(fields[0], ","), "yes")
+	}
+
+	var tests string
+	for i, field := range fields {
+		if i == 0 {
+			continue
+		}
+
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			// the test list may be folded after a comma
+			if tests != "" && strings.HasSuffix(tests, ",") {
+				tests += field
+			}
+
+			continue
+		}
+
+		switch strings.ToLower(kv[0]) {
+		case "score", "hits":
+			if f, err := strconv.ParseFloat(kv[1], 64); err == nil {
+				ss.Score = f
+			}
+		case "required":
+			ss.Required, _ = strconv.ParseFloat(kv[1], 64)
+		case "tests":
+			tests = kv[1]
+		}
+	}
+
+	for _, test := range strings.Split(tests, ",") {
+		if test != "" && test != "none" {
+			ss.Tests = append(ss.Tests, test)
+		}
+	}
+
+	return ss
+}
+
+// parseListID splits the RFC2919 List-Id header, e.g. "Go Nuts" <golang-nuts.googlegroups.com>, into the list
+// identifier and its optional description
+func parseListID(listID string) (id, description string) {
+	listID = strings.TrimSpace(listID)
+	start := strings.LastIndex(listID, "<")
+	end := strings.LastIndex(listID, ">")
+	if start < 0 || end < start {
+		return listID, ""
+	}
+
+	id = strings.TrimSpace(listID[start+1 : end])
+	description = strings.TrimSpace(listID[:start])
+	if unquoted, err := strconv.Unquote(description); err == nil && strings.HasPrefix(description, `"`) {
+		description = unquoted
+	}
+
+	return id, description
+}
+
+// parseListURIs returns the angle bracketed URIs of a RFC2369 List-* header, e.g.
+// <mailto:list-help@example.com?subject=help> (List Instructions), <https://example.com/help>
+func parseListURIs(value string) (uris []string) {
+	for {
+		start := strings.Index(value, "<")
+		if start < 0 {
+			return
+		}
+
+		end := strings.Index(value[start:], ">")
+		if end < 0 {
+			return
+		}
+
+		// URIs may be folded, whitespace within the brackets is ignored
+		if uri := strings.Join(strings.Fields(value[start+1:start+end]), ""); uri != "" {
+			uris = append(uris, uri)
+		}
+
+		value = value[start+end+1:]
+	}
+}
+
+// parseContentType parses a Content-Type header, defaulting to text/plain when it is missing as per RFC2045.
+// The boundary of a multipart Content-Type with malformed parameters is salvaged, see tolerantParams.
+func parseContentType(contentTypeHeader string) (contentType string, params map[string]string, err error) {
+	if strings.TrimSpace(contentTypeHeader) == "" {
+		contentType = contentTypeTextPlain
+		return
+	}
+
+	contentType, params, err = mime.ParseMediaType(contentTypeHeader)
+	if err == mime.ErrInvalidMediaParameter && strings.HasPrefix(contentType, "multipart/") {
+		if tolerant := tolerantParams(contentTypeHeader); tolerant["boundary"] != "" {
+			return contentType, tolerant, nil
+		}
+	}
+
+	return
+}
+
+// tolerantParams parses the parameters of a header value mime.ParseMediaType rejects, e.g. boundary="abc with an
+// unbalanced quote or boundary==_Part_1?x with unquoted special characters. Parameters without a value are
+// dropped and quoted values end at the closing quote, if any.
+func tolerantParams(header string) map[string]string {
+	params := map[string]string{}
+	for _, param := range splitHeaderParams(header)[1:] {
+		eq := strings.Index(param, "=")
+		if eq < 0 {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSpace(param[:eq]))
+		value := strings.TrimSpace(param[eq+1:])
+		if strings.HasPrefix(value, `"`) {
+			value = value[1:]
+			if end := strings.Index(value, `"`); end >= 0 {
+				value = value[:end]
+			}
+		}
+
+		if name != "" && value != "" {
+			params[name] = value
+		}
+	}
+
+	return params
+}
+
+// parseSMIME exposes an opaque S/MIME body (encrypted or signed-data) so callers can decrypt or verify it themselves
+func (p *parser) parseSMIME(email *Email, node *Part, body io.Reader, encoding string, smimeType string) error {
+	decoded, err := p.decodeContent(body, encoding, p.opts.MaxBodySize, node)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(decoded)
+	if err != nil {
+		return err
+	}
+
+	email.SMIMEType = strings.ToLower(smimeType)
+	email.IsEncrypted = email.SMIMEType == smimeTypeEnvelopedData
+	email.SMIMEData = bytes.NewReader(data)
+	email.Content = bytes.NewReader(data)
+
+	if email.IsEncrypted {
+		email.Encrypted = &EncryptedContent{Protocol: contentTypeApplicationPkcs7Mime, Data: data}
+	}
+
+	return nil
+}
+
+func (p *parser) parseMultipartRelated(email *Email, msg io.Reader, boundary string) (err error) {
+	depth := len(p.path)
+	if depth >= p.maxDepth() {
+		err = ErrMaxDepthExceeded
+		return
+	}
+
+	msg, empty := isEmptyBody(msg, boundary)
+	if empty {
+		return
+	}
+
+	pmr := multipart.NewReader(msg, boundary)
+	for index := 0; ; index++ {
+		part, pmrErr := p.nextPart(pmr, index)
+
+		if pmrErr == io.EOF {
+			break
+		} else if pmrErr != nil {
+			err = p.tolerate(pmrErr)
+			if err != nil {
+				return
+			}
+
+			break
+		}
+
+		p.enterPart(depth, boundary, index, part)
+
+		err = p.tolerate(p.partError(index, part, p.parseRelatedPart(email, part)))
+		if err != nil {
+			return
+		}
+	}
+
+	p.path = p.path[:depth]
+
+	return
+}
+
+// parseRelatedPart parses a single part of a multipart/related body
+func (p *parser) parseRelatedPart(email *Email, part *multipart.Part) error {
+	if p.opts.Repair && strings.TrimSpace(part.Header.Get("Content-Type")) == "" {
+		return p.repairPart(email, part)
+	}
+
+	contentType, params, err := parseContentType(part.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	switch contentType {
+	case contentTypeTextPlain, contentTypeTextHtml:
+		if isAttachment(part) {
+			return p.addAttachment(email, part)
+		}
+
+		return p.parseTextPart(email, p.node(part), part, part.Header.Get("Content-Transfer-Encoding"), contentType, params)
+	case contentTypeMultipartAlternative:
+		return p.parseMultipartAlternative(email, part, p.boundary(params))
+	default:
+		if !isEmbeddedFile(part) {
+			return fmt.Errorf("cannot process multipart/related inner mime type: %s", contentType)
+		}
+
+		ef, err := p.decodeEmbeddedFile(part)
+		if err != nil {
+			return err
+		}
+
+		email.EmbeddedFiles = append(email.EmbeddedFiles, ef)
+	}
+
+	return nil
+}
+
+func (p *parser) parseMultipartAlternative(email *Email, msg io.Reader, boundary string) (err error) {
+	depth := len(p.path)
+	if depth >= p.maxDepth() {
+		err = ErrMaxDepthExceeded
+		return
+	}
+
+	msg, empty := isEmptyBody(msg, boundary)
+	if empty {
+		return
+	}
+
+	pmr := multipart.NewReader(msg, boundary)
+	for index := 0; ; index++ {
+		part, pmrErr := p.nextPart(pmr, index)
+
+		if pmrErr == io.EOF {
+			break
+		} else if pmrErr != nil {
+			err = p.tolerate(pmrErr)
+			if err != nil {
+				return
+			}
+
+			break
+		}
+
+		p.enterPart(depth, boundary, index, part)
+
+		err = p.tolerate(p.partError(index, part, p.parseAlternativePart(email, part)))
+		if err != nil {
+			return
+		}
+	}
+
+	p.path = p.path[:depth]
+
+	return
+}
+
+// parseAlternativePart parses a single part of a multipart/alternative body
+func (p *parser) parseAlternativePart(email *Email, part *multipart.Part) error {
+	if p.opts.Repair && strings.TrimSpace(part.Header.Get("Content-Type")) == "" {
+		return p.repairPart(email, part)
+	}
+
+	contentType, params, err := parseContentType(part.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	switch contentType {
+	case contentTypeTextPlain, contentTypeTextHtml:
+		if isAttachment(part) {
+			return p.addAttachment(email, part)
+		}
+
+		return p.parseTextPart(email, p.node(part), part, part.Header.Get("Content-Transfer-Encoding"), contentType, params)
+	case contentTypeMultipartRelated:
+		return p.parseMultipartRelated(email, part, p.boundary(params))
+	default:
+		if isAttachment(part) {
+			return p.addAttachment(email, part)
+		} else if !isEmbeddedFile(part) {
+			return fmt.Errorf("cannot process multipart/alternative inner mime type: %s", contentType)
+		}
+
+		ef, err := p.decodeEmbeddedFile(part)
+		if err != nil {
+			return err
+		}
+
+		email.EmbeddedFiles = append(email.EmbeddedFiles, ef)
+	}
+
+	return nil
+}
+
+func (p *parser) parseMultipartMixed(email *Email, msg io.Reader, boundary string) (err error) {
+	depth := len(p.path)
+	if depth >= p.maxDepth() {
+		err = ErrMaxDepthExceeded
+		return
+	}
+
+	msg, empty := isEmptyBody(msg, boundary)
+	if empty {
+		return
+	}
+
+	pmr := multipart.NewReader(msg, boundary)
+	for index := 0; ; index++ {
+		part, pmrErr := p.nextPart(pmr, index)
+		if pmrErr == io.EOF {
+			break
+		} else if pmrErr != nil {
+			err = p.tolerate(pmrErr)
+			if err != nil {
+				return
+			}
+
+			break
+		}
+
+		p.enterPart(depth, boundary, index, part)
+
+		err = p.tolerate(p.partError(index, part, p.parseMixedPart(email, part)))
+		if err != nil {
+			return
+		}
+	}
+
+	p.path = p.path[:depth]
+
+	return
+}
+
+// parseMixedPart parses a single part of a multipart/mixed body
+func (p *parser) parseMixedPart(email *Email, part *multipart.Part) error {
+	if p.opts.Repair && strings.TrimSpace(part.Header.Get("Content-Type")) == "" {
+		return p.repairPart(email, part)
+	}
+
+	contentType, params, err := parseContentType(part.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	switch contentType {
+	case contentTypeMultipartAlternative:
+		return p.parseMultipartAlternative(email, part, p.boundary(params))
+
+	case contentTypeMultipartRelated:
+		return p.parseMultipartRelated(email, part, p.boundary(params))
+
+	case contentTypeMultipartAppleDouble:
+		return p.parseMultipartAppleDouble(email, part, p.boundary(params))
+
+	case contentTypeMultipartReport:
+		return p.parseMultipartReport(email, part, p.boundary(params))
+
+	case contentTypeMultipartSigned:
+		return p.parseMultipartSigned(email, part, p.boundary(params), params)
+
+	case contentTypeTextVCard, contentTypeTextXVCard:
+		vc, err := p.decodeVCard(part)
+		if err != nil {
+			return err
+		}
+
+		email.VCards = append(email.VCards, vc)
+
+	case contentTypeMessageRFC822:
+		// forwarded and bounced messages are often sent inline, keep them as attachments anyway
+		return p.addAttachment(email, part)
+
+	default:
+		if isAttachment(part) {
+			return p.addAttachment(email, part)
+		} else if contentType == contentTypeTextPlain || contentType == contentTypeTextHtml {
+			return p.parseTextPart(email, p.node(part), part, part.Header.Get("Content-Transfer-Encoding"), contentType, params)
+		} else if hasContentID(part) {
+			ef, err := p.decodeEmbeddedFile(part)
+			if err != nil {
+				return err
+			}
+
+			email.EmbeddedFiles = append(email.EmbeddedFiles, ef)
+		}
+	}
+
+	return nil
+}
+
+// addAttachment decodes an attachment part and adds it to the email, parsing message/rfc822 attachments and
+// unwrapping nested messages if asked to
+func (p *parser) addAttachment(email *Email, part *multipart.Part) error {
+	at, err := p.decodeAttachment(part)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(strings.TrimSpace(at.ContentType), contentTypeMessageRFC822) {
+		err = p.parseAttachedMessage(&at)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.opts.UnwrapNested {
+		err = p.unwrapNested(email, &at)
+		if err != nil {
+			return err
+		}
+	}
+
+	if at.Filename == "" {
+		at.Filename = fallbackFilename(at.ContentType, len(email.Attachments)+1)
+	}
+
+	email.Attachments = append(email.Attachments, at)
+
+	return nil
+}
+
+// repairPart classifies a part lacking a Content-Type by sniffing its decoded content, see Options.Repair
+func (p *parser) repairPart(email *Email, part *multipart.Part) error {
+	decoder, err := p.newDecoder(part, part.Header.Get("Content-Transfer-Encoding"))
+	if err != nil {
+		return err
+	}
+
+	// http.DetectContentType looks at the first 512 bytes only, which is enough to tell a body from an
+	// attachment and so which size limit applies before the rest of the content is read
+	br := bufio.NewReader(decoder)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	contentType, params, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return err
+	}
+
+	filename := p.fileName(part)
+	if filename == "" && (contentType == contentTypeTextPlain || contentType == contentTypeTextHtml) {
+		return p.parseTextPart(email, p.node(part), br, "", contentType, params)
+	}
+
+	decoded, err := p.decodeContent(br, "", p.opts.MaxAttachmentSize, p.node(part))
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(decoded)
+	if err != nil {
+		return err
+	}
+
+	at := Attachment{
+		Filename:          filename,
+		ContentType:       contentType,
+		Data:              bytes.NewReader(data),
+		CID:               strings.Trim(p.decodeMimeSentence(part.Header.Get("Content-Id")), "<>"),
+		Duration:          parseContentDuration(part.Header.Get("Content-Duration")),
+		Header:            cloneMIMEHeader(part.Header),
+		ContentTypeParams: params,
+		Checksum:          p.checksumBytes(data),
+	}
+
+	at.Disposition, at.DispositionParams = parseContentDisposition(part.Header.Get("Content-Disposition"))
+	if at.Disposition == "" {
+		at.Disposition = "attachment"
+	}
+
+	if at.Filename == "" {
+		at.Filename = fallbackFilename(at.ContentType, len(email.Attachments)+1)
+	}
+
+	email.Attachments = append(email.Attachments, at)
+
+	return nil
+}
+
+// parseMultipartEncrypted exposes the control information and the encrypted data of a RFC1847 multipart/encrypted
+// body (e.g. PGP/MIME) in Email.Encrypted so callers can decrypt it themselves
+func (p *parser) parseMultipartEncrypted(email *Email, msg io.Reader, boundary string, protocol string) (err error) {
+	depth := len(p.path)
+	if depth >= p.maxDepth() {
+		err = ErrMaxDepthExceeded
+		return
+	}
+
+	email.IsEncrypted = true
+	email.Encrypted = &EncryptedContent{Protocol: strings.ToLower(protocol)}
+
+	msg, empty := isEmptyBody(msg, boundary)
+	if empty {
+		return
+	}
+
+	pmr := multipart.NewReader(msg, boundary)
+	for index := 0; index < 2; index++ {
+		part, pmrErr := p.nextPart(pmr, index)
+		if pmrErr == io.EOF {
+			break
+		} else if pmrErr != nil {
+			err = p.tolerate(pmrErr)
+			if err != nil {
+				return
+			}
+
+			break
+		}
+
+		p.enterPart(depth, boundary, index, part)
+
+		// the first part holds the control information, the second one the encrypted data
+		decoded, decodeErr := p.decodeContent(part, part.Header.Get("Content-Transfer-Encoding"), p.opts.MaxAttachmentSize, p.node(part))
+		if decodeErr != nil {
+			err = p.tolerate(p.partError(index, part, decodeErr))
+			if err != nil {
+				return
+			}
+
+			continue
+		}
+
+		data, _ := io.ReadAll(decoded)
+		if index == 0 {
+			email.Encrypted.Control = data
+		} else {
+			email.Encrypted.Data = data
+		}
+	}
+
+	p.path = p.path[:depth]
+
+	return
+}
+
+// parseMultipartSigned parses the signed part of a RFC1847 multipart/signed body like any other part and exposes
+// it as received along with the signature in Email.Signature, so that callers can verify the signature
+func (p *parser) parseMultipartSigned(email *Email, msg io.Reader, boundary string, params map[string]string) (err error) {
+	depth := len(p.path)
+	if depth >= p.maxDepth() {
+		err = ErrMaxDepthExceeded
+		return
+	}
+
+	body, err := io.ReadAll(msg)
+	if err != nil {
+		return
+	}
+
+	email.Signature = &Signature{
+		Protocol:      strings.ToLower(params["protocol"]),
+		MICAlg:        strings.ToLower(params["micalg"]),
+		SignedContent: signedContent(body, boundary),
+	}
+
+	msg, empty := isEmptyBody(bytes.NewReader(body), boundary)
+	if empty {
+		return
+	}
+
+	pmr := multipart.NewReader(msg, boundary)
+	for index := 0; ; index++ {
+		part, pmrErr := p.nextPart(pmr, index)
+		if pmrErr == io.EOF {
+			break
+		} else if pmrErr != nil {
+			err = p.tolerate(pmrErr)
+			if err != nil {
+				return
+			}
+
+			break
+		}
+
+		p.enterPart(depth, boundary, index, part)
+
+		err = p.tolerate(p.partError(index, part, p.parseSignedPart(email, part, index)))
+		if err != nil {
+			return
+		}
+	}
+
+	p.path = p.path[:depth]
+
+	return
+}
+
+// parseSignedPart parses a single part of a multipart/signed body, the first being the signed one and the second
+// the signature
+func (p *parser) parseSignedPart(email *Email, part *multipart.Part, index int) error {
+	switch index {
+	case 0:
+		contentType, params, err := parseContentType(part.Header.Get("Content-Type"))
+		if err != nil {
+			return err
+		}
+
+		if contentType == contentTypeMultipartMixed {
+			return p.parseMultipartMixed(email, part, p.boundary(params))
+		}
+
+		return p.parseMixedPart(email, part)
+
+	case 1:
+		decoded, err := p.decodeContent(part, part.Header.Get("Content-Transfer-Encoding"), p.opts.MaxAttachmentSize, p.node(part))
+		if err != nil {
+			return err
+		}
+
+		email.Signature.Data, err = io.ReadAll(decoded)
+		return err
+	}
+
+	return nil
+}
+
+// signedContent returns the first part of a multipart body byte for byte, headers included, as covered by the
+// signature of a multipart/signed body. The line break before the delimiter belongs to the delimiter.
+func signedContent(body []byte, boundary string) []byte {
+	delimiter := []byte("--" + boundary)
+
+	start := 0
+	if !bytes.HasPrefix(body, delimiter) {
+		start = bytes.Index(body, append([]byte("\n"), delimiter...))
+		if start < 0 {
+			return nil
+		}
+
+		start++
+	}
+
+	eol := bytes.IndexByte(body[start:], '\n')
+	if eol < 0 {
+		return nil
+	}
+
+	start += eol + 1
+
+	end := bytes.Index(body[start:], append([]byte("\n"), delimiter...))
+	if end < 0 {
+		return nil
+	}
+
+	return bytes.TrimSuffix(body[start:start+end], []byte("\r"))
+}
+
+// parseMultipartReport parses a RFC6522 multipart/report, e.g. a bounce, into Email.Report. The human readable
+// part becomes the text or html body and a returned message/rfc822 original is added to the attachments as well.
+func (p *parser) parseMultipartReport(email *Email, msg io.Reader, boundary string) (err error) {
+	depth := len(p.path)
+	if depth >= p.maxDepth() {
+		err = ErrMaxDepthExceeded
+		return
+	}
+
+	email.Report = &DeliveryReport{}
+
+	msg, empty := isEmptyBody(msg, boundary)
+	if empty {
+		return
+	}
+
+	pmr := multipart.NewReader(msg, boundary)
+	for index := 0; ; index++ {
+		part, pmrErr := p.nextPart(pmr, index)
+		if pmrErr == io.EOF {
+			break
+		} else if pmrErr != nil {
+			err = p.tolerate(pmrErr)
+			if err != nil {
+				return
+			}
+
+			break
+		}
+
+		p.enterPart(depth, boundary, index, part)
+
+		err = p.tolerate(p.partError(index, part, p.parseReportPart(email, part)))
+		if err != nil {
+			return
+		}
+	}
+
+	p.path = p.path[:depth]
+
+	return
+}
+
+// parseReportPart parses a single part of a multipart/report body
+func (p *parser) parseReportPart(email *Email, part *multipart.Part) error {
+	contentType, _, err := parseContentType(part.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	switch contentType {
+	case contentTypeMessageDeliveryStatus:
+		decoded, err := p.decodeContent(part, part.Header.Get("Content-Transfer-Encoding"), p.opts.MaxBodySize, p.node(part))
+		if err != nil {
+			return err
+		}
+
+		return parseDeliveryStatus(email.Report, decoded)
+
+	case contentTypeTextRFC822Headers:
+		decoded, err := p.decodeContent(part, part.Header.Get("Content-Transfer-Encoding"), p.opts.MaxBodySize, p.node(part))
+		if err != nil {
+			return err
+		}
+
+		header, err := textproto.NewReader(bufio.NewReader(decoded)).ReadMIMEHeader()
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		email.Report.OriginalHeader = mail.Header(header)
+
+	case contentTypeMessageRFC822:
+		err = p.addAttachment(email, part)
+		if err != nil {
+			return err
+		}
+
+		if original := email.Attachments[len(email.Attachments)-1].Message; original != nil {
+			email.Report.Original = original
+			email.Report.OriginalHeader = original.Header
+		}
+
+	default:
+		return p.parseMixedPart(email, part)
+	}
+
+	return nil
+}
+
+// parseDeliveryStatus parses the RFC3464 message/delivery-status fields: a group of per-message fields followed by
+// a group of fields for each recipient, the groups being separated by blank lines
+func parseDeliveryStatus(report *DeliveryReport, content io.Reader) error {
+	tp := textproto.NewReader(bufio.NewReader(content))
+
+	first := true
+	for {
+		fields, err := tp.ReadMIMEHeader()
+		if len(fields) > 0 {
+			if first {
+				report.ReportingMTA = deliveryStatusValue(fields.Get("Reporting-MTA"))
+				report.ArrivalDate = parseOptionalTime(fields.Get("Arrival-Date"))
+				first = false
+			} else {
+				report.Recipients = append(report.Recipients, RecipientStatus{
+					FinalRecipient:    deliveryStatusValue(fields.Get("Final-Recipient")),
+					OriginalRecipient: deliveryStatusValue(fields.Get("Original-Recipient")),
+					Action:            strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+					Status:            strings.TrimSpace(strings.Split(fields.Get("Status"), "(")[0]),
+					DiagnosticCode:    deliveryStatusValue(fields.Get("Diagnostic-Code")),
+					RemoteMTA:         deliveryStatusValue(fields.Get("Remote-MTA")),
+				})
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// deliveryStatusValue strips the type from a typed delivery status field, e.g. "rfc822; jdoe@example.com"
+func deliveryStatusValue(field string) string {
+	if semicolon := strings.Index(field, ";"); semicolon >= 0 {
+		field = field[semicolon+1:]
+	}
+
+	return strings.TrimSpace(field)
+}
+
+// parseMultipartAppleDouble extracts the data part of a multipart/appledouble (as sent by macOS Mail) as an
+// attachment. The application/applefile part holding the resource fork is exposed as Attachment.ResourceFork.
+func (p *parser) parseMultipartAppleDouble(email *Email, msg io.Reader, boundary string) (err error) {
+	depth := len(p.path)
+	if depth >= p.maxDepth() {
+		err = ErrMaxDepthExceeded
+		return
+	}
+
+	var resourceFork io.Reader
+
+	msg, empty := isEmptyBody(msg, boundary)
+	if empty {
+		return
+	}
+
+	pmr := multipart.NewReader(msg, boundary)
+	for index := 0; ; index++ {
+		part, pmrErr := p.nextPart(pmr, index)
+		if pmrErr == io.EOF {
+			break
+		} else if pmrErr != nil {
+			err = p.tolerate(pmrErr)
+			if err != nil {
+				return
+			}
+
+			break
+		}
+
+		p.enterPart(depth, boundary, index, part)
+
+		contentType, _, mimeErr := parseContentType(part.Header.Get("Content-Type"))
+		if mimeErr != nil {
+			err = p.tolerate(p.partError(index, part, mimeErr))
+			if err != nil {
+				return
+			}
+
+			continue
+		}
+
+		if contentType == contentTypeApplicationAppleFile {
+			resourceFork, err = p.decodeContent(part, part.Header.Get("Content-Transfer-Encoding"), p.opts.MaxAttachmentSize, p.node(part))
+			if err != nil {
+				err = p.tolerate(p.partError(index, part, err))
+				if err != nil {
+					return
+				}
+			}
+
+			continue
+		}
+
+		at, aErr := p.decodeAttachment(part)
+		if aErr != nil {
+			err = p.tolerate(p.partError(index, part, aErr))
+			if err != nil {
+				return
+			}
+
+			continue
+		}
+
+		at.ResourceFork = resourceFork
+		if at.Filename == "" {
+			at.Filename = fallbackFilename(at.ContentType, len(email.Attachments)+1)
+		}
+
+		email.Attachments = append(email.Attachments, at)
+	}
+
+	p.path = p.path[:depth]
+
+	return
+}
+
+// isEmptyBody reports whether a multipart body holds no parts, i.e. nothing but whitespace and possibly the
+// closing delimiter, in which case it is empty rather than malformed. The returned reader yields the whole body.
+func isEmptyBody(body io.Reader, boundary string) (io.Reader, bool) {
+	br := bufio.NewReader(body)
+
+	n := 0
+	for ; ; n++ {
+		b, err := br.Peek(n + 1)
+		if err != nil {
+			return br, err == io.EOF
+		}
+
+		if b[n] != ' ' && b[n] != '\t' && b[n] != '\r' && b[n] != '\n' {
+			break
+		}
+	}
+
+	b, _ := br.Peek(n + len(boundary) + 4)
+	return br, bytes.HasPrefix(b[n:], []byte("--"+boundary+"--"))
+}
+
+// unstuffDots turns a message as sent in the SMTP DATA command back into the original message: leading dots
+// doubled by the sender are removed and reading stops at the terminating "." line, which may be missing.
+// Unlike textproto.DotReader it keeps line endings as they are, so signed content stays byte for byte intact.
+func unstuffDots(r io.Reader) io.Reader {
+	return &dotReader{r: bufio.NewReader(r)}
+}
+
+// dotReader reads a dot-stuffed message line by line
+type dotReader struct {
+	r       *bufio.Reader
+	midLine bool
+	done    bool
+}
+
+func (dr *dotReader) Read(b []byte) (int, error) {
+	if dr.done {
+		return 0, io.EOF
+	}
+
+	if !dr.midLine {
+		rest, err := dr.r.Peek(3)
+		if len(rest) > 0 && rest[0] == '.' {
+			if bytes.HasPrefix(rest, []byte(".\n")) || bytes.Equal(rest, []byte(".\r\n")) ||
+				(err != nil && string(bytes.TrimRight(rest, "\r")) == ".") {
+				dr.done = true
+				return 0, io.EOF
+			}
+
+			dr.r.Discard(1)
+		}
+
+		dr.midLine = true
+	}
+
+	n := 0
+	for n < len(b) {
+		c, err := dr.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+
+		b[n] = c
+		n++
+		if c == '\n' {
+			dr.midLine = false
+			break
+		}
+	}
+
+	return n, nil
+}
+
+// stripBOM skips a UTF-8 byte order mark at the very start of a message, as written by some Windows tools
+func stripBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		br.Discard(len(utf8BOM))
+	}
+
+	return br
+}
+
+// limitHeader buffers the header block of a message and fails with ErrHeadersTooLarge once it exceeds limit
+// bytes, before net/mail gets a chance to read it whole. The returned reader yields the complete message.
+func limitHeader(r io.Reader, limit int64) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header := bytes.Buffer{}
+	lineStart := true
+
+	for {
+		line, err := br.ReadSlice('\n')
+		header.Write(line)
+		if int64(header.Len()) > limit {
+			return nil, ErrHeadersTooLarge
+		}
+
+		if lineStart && (string(line) == "\n" || string(line) == "\r\n") {
+			break
+		}
+
+		if err == bufio.ErrBufferFull {
+			lineStart = false
+			continue
+		} else if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		lineStart = true
+	}
+
+	return io.MultiReader(&header, br), nil
+}
+
+// parseAttachedMessage parses a message/rfc822 attachment into Attachment.Message. A malformed message, or one
+// nested deeper than maxMessageNesting, is kept as a plain attachment.
+func (p *parser) parseAttachedMessage(at *Attachment) error {
+	if p.nesting >= maxMessageNesting {
+		return nil
+	}
+
+	data, err := io.ReadAll(at.Data)
+	if err != nil {
+		return err
+	}
+
+	at.Data = bytes.NewReader(data)
+
+	// the attached message is already transfer-decoded, dot-stuffing only applies to the outer message
+	opts := p.opts
+	opts.SMTPDotStuffed = false
+
+	subParser := parser{opts: opts, ctx: p.ctx, nesting: p.nesting + 1}
+	msg, err := subParser.parse(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, ErrSizeLimitExceeded) {
+			return err
+		}
+
+		p.warn(fmt.Errorf("attached message: %w", err))
+		return nil
+	}
+
+	at.Message = &msg
+
+	return nil
+}
+
+// unwrapNested parses an attachment that holds a complete email message (e.g. one re-wrapped by an
+// anti-virus gateway) and appends it to SubMessages. The attachment itself is kept untouched, a message
+// already parsed into at.Message is reused rather than parsed again.
+func (p *parser) unwrapNested(email *Email, at *Attachment) error {
+	if p.nesting >= maxMessageNesting {
+		return nil
+	}
+
+	if at.Message != nil {
+		email.SubMessages = append(email.SubMessages, *at.Message)
+		return nil
+	}
+
+	data, err := io.ReadAll(at.Data)
+	if err != nil {
+		return err
+	}
+
+	at.Data = bytes.NewReader(data)
+	if !looksLikeMessage(data) {
+		return nil
+	}
+
+	// the wrapped message is already transfer-decoded, dot-stuffing only applies to the outer message
+	opts := p.opts
+	opts.SMTPDotStuffed = false
+
+	subParser := parser{opts: opts, ctx: p.ctx, nesting: p.nesting + 1}
+	sub, err := subParser.parse(bytes.NewReader(data))
+	if err != nil {
+		// not a message after all, keep it as a plain attachment
+		return nil
+	}
+
+	email.SubMessages = append(email.SubMessages, sub)
+
+	return nil
+}
+
+// looksLikeMessage sniffs whether data starts with an RFC5322 header block containing the mandatory From and Date fields
+func looksLikeMessage(data []byte) bool {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+
+	return msg.Header.Get("From") != "" && msg.Header.Get("Date") != ""
+}
+
+// boundary returns the boundary a multipart body is split with, honouring Options.BoundaryOverride
+func (p *parser) boundary(params map[string]string) string {
+	boundary := params["boundary"]
+	if override, ok := p.opts.BoundaryOverride[boundary]; ok {
+		return override
+	}
+
+	if p.opts.Lenient {
+		// some generators declare boundary="abc " while the delimiter lines omit the trailing space
+		return strings.TrimRight(boundary, " \t")
+	}
+
+	return boundary
+}
+
+// parseTextPart decodes a text/plain or text/html part, appends it to the matching body and records its declared charset
+func (p *parser) parseTextPart(email *Email, node *Part, part io.Reader, encoding string, contentType string, params map[string]string) error {
+	decoded, err := p.decodeContent(part, encoding, p.opts.MaxBodySize, node)
+	if err != nil {
+		return err
+	}
+
+	ppContent, err := io.ReadAll(decoded)
+	if err != nil {
+		return err
+	}
+
+	charset := strings.ToLower(params["charset"])
+	if charset == "" && contentType == contentTypeTextHtml {
+		charset = htmlCharset(ppContent)
+	}
+
+	raw := ppContent
+	ppContent = p.decodeCharset(raw, charset)
+
+	// the declared charset lies if decoding with it yields replacement characters, see Options.CharsetReader
+	if contentType == contentTypeTextHtml && p.opts.CharsetReader != nil && params["charset"] != "" &&
+		(!utf8.Valid(ppContent) || bytes.ContainsRune(ppContent, utf8.RuneError)) {
+		if sniffed := htmlCharset(raw); sniffed != charset {
+			charset, ppContent = sniffed, p.decodeCharset(raw, sniffed)
+		}
+	}
+
+	switch contentType {
+	case contentTypeTextPlain:
+		body := p.normalizeBody(string(ppContent))
+		if p.opts.Uudecode {
+			body, err = p.extractUuencoded(email, body)
+			if err != nil {
+				return err
+			}
+		}
+
+		email.TextBody += body
+		email.TextBodies = append(email.TextBodies, body)
+		email.TextBodyCharsets = append(email.TextBodyCharsets, charset)
+		if email.TextBodyCharset == "" {
+			email.TextBodyCharset = charset
+		}
+	case contentTypeTextHtml:
+		body := p.normalizeBody(string(ppContent))
+		email.HTMLBody += body
+		email.HTMLBodies = append(email.HTMLBodies, body)
+		if email.HTMLBodyCharset == "" {
+			email.HTMLBodyCharset = charset
+		}
+	}
+
+	return nil
+}
+
+// extractUuencoded cuts the uuencoded "begin 644 name" ... "end" blocks out of a text body and adds them to the
+// attachments, see Options.Uudecode. Blocks which cannot be decoded are left in the text.
+func (p *parser) extractUuencoded(email *Email, text string) (string, error) {
+	lines := strings.Split(text, "\n")
+	kept := make([]string, 0, len(lines))
+
+	for i := 0; i < len(lines); i++ {
+		m := uuencodeBeginRegexp.FindStringSubmatch(strings.TrimRight(lines[i], "\r"))
+		if m == nil {
+			kept = append(kept, lines[i])
+			continue
+		}
+
+		data, n, ok := uudecode(lines[i+1:])
+		if !ok {
+			kept = append(kept, lines[i])
+			continue
+		}
+
+		if p.opts.MaxAttachmentSize > 0 && int64(len(data)) > p.opts.MaxAttachmentSize {
+			return "", ErrSizeLimitExceeded
+		}
+
+		contentType := mediaType(mime.TypeByExtension(path.Ext(m[1])))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		email.Attachments = append(email.Attachments, Attachment{
+			Filename:    m[1],
+			ContentType: contentType,
+			Data:        bytes.NewReader(data),
+			Disposition: "attachment",
+			Checksum:    p.checksumBytes(data),
+		})
+
+		i += n
+	}
+
+	return strings.Join(kept, "\n"), nil
+}
+
+// uudecode decodes the lines of a uuencoded block following its begin line up to the end line. n is the number
+// of lines consumed including the end line, ok is false when the block is malformed or lacks its end line.
+func uudecode(lines []string) (data []byte, n int, ok bool) {
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if line == "end" {
+			return data, i + 1, true
+		} else if line == "" {
+			continue
+		}
+
+		length := int(line[0]-' ') & 63
+		if length == 0 {
+			continue
+		}
+
+		// encoders may strip trailing spaces, which encode zero bits
+		chars := line[1:]
+		if need := (length + 2) / 3 * 4; len(chars) < need {
+			chars += strings.Repeat(" ", need-len(chars))
+		}
+
+		var decoded []byte
+		for j := 0; len(decoded) < length; j += 4 {
+			var group [4]byte
+			for k := range group {
+				c := chars[j+k]
+				if c < ' ' || c > '`' {
+					return nil, 0, false
+				}
+
+				group[k] = (c - ' ') & 63
+			}
+
+			decoded = append(decoded, group[0]<<2|group[1]>>4, group[1]<<4|group[2]>>2, group[2]<<6|group[3])
+		}
+
+		data = append(data, decoded[:length]...)
+	}
+
+	return nil, 0, false
+}
+
+// normalizeBody converts the line endings of a body to LF if Options.NormalizeNewlines is set and drops its final
+// "\n" unless Options.PreserveTrailingNewline is set. Otherwise the "\r" of a final CRLF is kept.
+func (p *parser) normalizeBody(body string) string {
+	if p.opts.NormalizeNewlines {
+		body = strings.Replace(strings.Replace(body, "\r\n", "\n", -1), "\r", "\n", -1)
+	}
+
+	if p.opts.PreserveTrailingNewline {
+		return body
+	}
+
+	return strings.TrimSuffix(body, "\n")
+}
+
+// sniffContentType detects the content type of the content in *r with http.DetectContentType, see
+// Options.SniffContentType
+func sniffContentType(r *io.Reader) (string, error) {
+	b, err := bufferReader(r)
+	if err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(b), nil
+}
+
+// decodeCharset converts text in the given charset to UTF-8, see convertCharset. Text in an unsupported charset
+// is returned unchanged.
+func (p *parser) decodeCharset(content []byte, charset string) []byte {
+	decoded, err := p.convertCharset(content, charset)
+	if err != nil {
+		return content
+	}
+
+	return decoded
+}
+
+// convertCharset converts text in the given charset to UTF-8 using Options.CharsetReader, falling back to the
+// WHATWG encodings known to browsers (e.g. ISO-8859-1, windows-1252, Shift_JIS)
+func (p *parser) convertCharset(content []byte, charset string) ([]byte, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return content, nil
+	}
+
+	if p.opts.CharsetReader != nil {
+		if r, err := p.opts.CharsetReader(charset, bytes.NewReader(content)); err == nil {
+			if decoded, err := io.ReadAll(r); err == nil {
+				return decoded, nil
+			}
+		}
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	}
+
+	return enc.NewDecoder().Bytes(content)
+}
+
+// charsetReader converts RFC2047 encoded-words in charsets other than UTF-8 and ISO-8859-1, see convertCharset
+func (p *parser) charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	content, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := p.convertCharset(content, charset)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(decoded), nil
+}
+
+// htmlCharset returns the charset of an html document lacking a usable charset parameter the way browsers
+// determine it with charset.DetermineEncoding: from a byte order mark or a <meta> tag, else utf-8 for valid
+// UTF-8 text and windows-1252 otherwise
+func htmlCharset(content []byte) string {
+	_, name, _ := htmlcharset.DetermineEncoding(content, contentTypeTextHtml)
+	return name
+}
+
+// wordDecoder returns a decoder of RFC2047 encoded-words supporting the charsets of convertCharset
+func (p *parser) wordDecoder() *mime.WordDecoder {
+	return &mime.WordDecoder{CharsetReader: p.charsetReader}
+}
+
+// decodeMimeSentence decodes the RFC2047 encoded-words of a header value. Whitespace between adjacent
+// encoded-words is dropped. A value which cannot be decoded is returned as it is.
+func (p *parser) decodeMimeSentence(s string) string {
+	decoded, err := p.wordDecoder().DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+
+	return decoded
+}
+
+// fileName returns the decoded filename of a part. Unlike part.FileName it also decodes RFC2231 extended
+// parameters in charsets other than UTF-8, e.g. filename*=iso-8859-1'fr'caf%E9.pdf
+func (p *parser) fileName(part *multipart.Part) string {
+	// mime.ParseMediaType drops the sections of a continued parameter in such charsets but keeps the others, so
+	// extended parameters are always assembled here
+	disposition := part.Header.Get("Content-Disposition")
+	for _, param := range splitHeaderParams(disposition)[1:] {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(param)), "filename*") {
+			if name := p.extendedParam(disposition, "filename"); name != "" {
+				return name
+			}
+
+			break
+		}
+	}
+
+	return p.decodeMimeSentence(part.FileName())
+}
+
+// extendedParam assembles the RFC2231 extended parameter name of a header value from its charset'language'value
+// form and numbered continuations, and converts it to UTF-8. It serves the charsets mime.ParseMediaType drops.
+func (p *parser) extendedParam(header string, name string) string {
+	var charset string
+	sections := map[int]string{}
+
+	for _, param := range splitHeaderParams(header) {
+		eq := strings.Index(param, "=")
+		if eq < 0 {
+			continue
+		}
+
+		attr := strings.ToLower(strings.TrimSpace(param[:eq]))
+		value := strings.TrimSpace(param[eq+1:])
+		if !strings.HasPrefix(attr, name+"*") {
+			continue
+		}
+
+		// the rest of the attribute is empty (name*), a section number (name*1) or an encoded section (name*1*)
+		rest := attr[len(name)+1:]
+		extended := rest == "" || strings.HasSuffix(rest, "*")
+
+		section := 0
+		if rest != "" {
+			n, err := strconv.Atoi(strings.TrimSuffix(rest, "*"))
+			if err != nil {
+				continue
+			}
+
+			section = n
+		}
+
+		if !extended {
+			sections[section] = strings.Trim(value, `"`)
+			continue
+		}
+
+		if section == 0 {
+			fields := strings.SplitN(value, "'", 3)
+			if len(fields) != 3 {
+				continue
+			}
+
+			charset, value = fields[0], fields[2]
+		}
+
+		decoded, err := url.PathUnescape(value)
+		if err != nil {
+			return ""
+		}
+
+		sections[section] = decoded
+	}
+
+	var value strings.Builder
+	for i := 0; ; i++ {
+		section, ok := sections[i]
+		if !ok {
+			break
+		}
+
+		value.WriteString(section)
+	}
+
+	decoded, err := p.convertCharset([]byte(value.String()), charset)
+	if err != nil {
+		return value.String()
+	}
+
+	return string(decoded)
+}
+
+// splitHeaderParams splits a structured header value on the semicolons outside of quoted strings, the first
+// element being the value itself (e.g. the disposition type) followed by its parameters
+func splitHeaderParams(header string) []string {
+	var params []string
+	var quoted, escaped bool
+
+	start := 0
+	for i := 0; i < len(header); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case header[i] == '\\' && quoted:
+			escaped = true
+		case header[i] == '"':
+			quoted = !quoted
+		case header[i] == ';' && !quoted:
+			params = append(params, header[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(params, header[start:])
+}
+
+func (p *parser) decodeHeaderMime(header mail.Header) (mail.Header, error) {
+	parsedHeader := map[string][]string{}
+
+	for headerName, headerData := range header {
+
+		parsedHeaderData := []string{}
+		for _, headerValue := range headerData {
+			parsedHeaderData = append(parsedHeaderData, p.decodeMimeSentence(headerValue))
+		}
+
+		parsedHeader[headerName] = parsedHeaderData
+	}
+
+	return mail.Header(parsedHeader), nil
+}
+
+func isEmbeddedFile(part *multipart.Part) bool {
+	return part.Header.Get("Content-Transfer-Encoding") != "" || hasContentID(part)
+}
+
+func hasContentID(part *multipart.Part) bool {
+	return part.Header.Get("Content-Id") != ""
+}
+
+func (p *parser) decodeEmbeddedFile(part *multipart.Part) (ef EmbeddedFile, err error) {
+	cid := p.decodeMimeSentence(part.Header.Get("Content-Id"))
+	decoded, err := p.decodeContent(part, part.Header.Get("Content-Transfer-Encoding"), p.opts.MaxAttachmentSize, p.node(part))
+	if err != nil {
+		return
+	}
+
+	_, params, _ := parseContentType(part.Header.Get("Content-Type"))
+	if name, ok := params["name"]; ok {
+		ef.Filename = name
+	}
+
+	ef.Checksum, err = p.checksum(&decoded)
+	if err != nil {
+		return
+	}
+
+	ef.CID = strings.Trim(cid, "<>")
+	ef.Data = decoded
+	ef.ContentType = part.Header.Get("Content-Type")
+	ef.Disposition, ef.DispositionParams = parseContentDisposition(part.Header.Get("Content-Disposition"))
+	ef.Header = cloneMIMEHeader(part.Header)
+
+	return
+}
+
+func (p *parser) decodeVCard(part *multipart.Part) (vc VCard, err error) {
+	decoded, err := p.decodeContent(part, part.Header.Get("Content-Transfer-Encoding"), p.opts.MaxAttachmentSize, p.node(part))
+	if err != nil {
+		return
+	}
+
+	vc.Raw, err = io.ReadAll(decoded)
+	if err != nil {
+		return
+	}
+
+	vc.Filename = p.fileName(part)
+	vc.FullName, vc.Emails = parseVCardContact(vc.Raw)
+
+	return
+}
+
+// parseVCardContact extracts the first FN (full name) and all the EMAIL properties of a vCard. This is not a full
+// vCard parser, property parameters are ignored and values are not unescaped.
+func parseVCardContact(raw []byte) (fullName string, emails []string) {
+	unfolded := strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(string(raw))
+
+	for _, line := range strings.Split(unfolded, "\n") {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+
+		name := strings.ToUpper(strings.Split(line[:colon], ";")[0])
+		if dot := strings.LastIndex(name, "."); dot >= 0 {
+			// drop the group prefix, e.g. item1.EMAIL
+			name = name[dot+1:]
+		}
+
+		value := strings.TrimSpace(line[colon+1:])
+		switch name {
+		case "FN":
+			if fullName == "" {
+				fullName = value
+			}
+		case "EMAIL":
+			if value != "" {
+				emails = append(emails, value)
+			}
+		}
+	}
+
+	return
+}
+
+// isAttachment reports whether a part is an attachment, i.e. it has an attachment disposition or a filename
+func isAttachment(part *multipart.Part) bool {
+	disposition, _ := parseContentDisposition(part.Header.Get("Content-Disposition"))
+	return disposition == "attachment" || part.FileName() != ""
+}
+
+func (p *parser) decodeAttachment(part *multipart.Part) (at Attachment, err error) {
+	filename := p.fileName(part)
+	encoding := part.Header.Get("Content-Transfer-Encoding")
+
+	var decoded io.Reader
+	if p.source != nil {
+		// fail on an unknown encoding right away rather than on first read
+		if _, err = p.newDecoder(part, encoding); err != nil {
+			return
+		}
+
+		decoded = &lazyPartReader{
+			p:        p,
+			source:   p.source,
+			start:    p.sourceStart,
+			path:     append([]partLocation(nil), p.path...),
+			encoding: encoding,
+		}
+	} else {
+		decoded, err = p.decodeContent(part, encoding, p.opts.MaxAttachmentSize, p.node(part))
+		if err != nil {
+			return
+		}
+
+		at.Checksum, err = p.checksum(&decoded)
+		if err != nil {
+			return
+		}
+	}
+
+	at.Filename = filename
+	at.CID = strings.Trim(p.decodeMimeSentence(part.Header.Get("Content-Id")), "<>")
+	at.Data = decoded
+	at.ContentType = strings.Split(part.Header.Get("Content-Type"), ";")[0]
+	at.Disposition, at.DispositionParams = parseContentDisposition(part.Header.Get("Content-Disposition"))
+	at.Duration = parseContentDuration(part.Header.Get("Content-Duration"))
+	at.Header = cloneMIMEHeader(part.Header)
+
+	if _, params, err := mime.ParseMediaType(part.Header.Get("Content-Type")); err == nil {
+		at.ContentTypeParams = params
+		at.MacType = parseMacCode(params["x-mac-type"])
+		at.MacCreator = parseMacCode(params["x-mac-creator"])
+	}
+
+	return
+}
+
+// checksum returns the hex encoded checksum of the decoded content in *data, see Options.ChecksumHash
+func (p *parser) checksum(data *io.Reader) (string, error) {
+	b, err := bufferReader(data)
+	if err != nil {
+		return "", err
+	}
+
+	return p.checksumBytes(b), nil
+}
+
+func (p *parser) checksumBytes(b []byte) string {
+	newHash := p.opts.ChecksumHash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+
+	h := newHash()
+	h.Write(b)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// preferredExtensions picks the usual extension of content types mime.ExtensionsByType knows several for
+var preferredExtensions = map[string]string{
+	"image/jpeg":     ".jpg",
+	"text/plain":     ".txt",
+	"text/html":      ".html",
+	"message/rfc822": ".eml",
+}
+
+// fallbackFilename names the index-th (1-based) attachment of an email which has no filename, e.g.
+// attachment-3.pdf, the extension being derived from its content type
+func fallbackFilename(contentType string, index int) string {
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+
+	ext, ok := preferredExtensions[contentType]
+	if !ok {
+		if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+
+	return fmt.Sprintf("attachment-%d%s", index, ext)
+}
+
+// parseMacCode decodes a four character Mac OS type or creator code given in hex as per RFC1740. Values which
+// are not hex encoded are returned as they are.
+func parseMacCode(code string) string {
+	if b, err := hex.DecodeString(code); err == nil && len(b) == 4 {
+		return string(b)
+	}
+
+	return code
+}
+
+// parseContentDuration parses the RFC3803 Content-Duration header given in whole seconds. A missing or
+// malformed header yields zero.
+func parseContentDuration(contentDuration string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(contentDuration))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// parseContentDisposition returns the lowercased disposition type (e.g. attachment, inline, form-data) and its
+// parameters. A missing or malformed header yields an empty disposition.
+func parseContentDisposition(contentDisposition string) (disposition string, params map[string]string) {
+	if contentDisposition == "" {
+		return
+	}
+
+	disposition, params, _ = mime.ParseMediaType(contentDisposition)
+
+	return
+}
+
+// decodeContent transfer-decodes content into memory, failing with ErrSizeLimitExceeded once it exceeds limit
+// bytes. Zero means no limit. The decoded content is recorded as the Content of node, the Email.Structure node
+// of the part being decoded, unless node is nil.
+func (p *parser) decodeContent(content io.Reader, encoding string, limit int64, node *Part) (io.Reader, error) {
+	decoder, err := p.newDecoder(content, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := io.ReadAll(limitSize(decoder, limit))
+	if err != nil {
+		return nil, err
+	}
+
+	if node != nil {
+		node.Content = b
+	}
+
+	return bytes.NewReader(b), nil
+}
+
+// newDecoder returns a reader transfer-decoding content on the fly
+func (p *parser) newDecoder(content io.Reader, encoding string) (io.Reader, error) {
+	if p.opts.StrictErrors {
+		switch strings.ToLower(strings.TrimSpace(encoding)) {
+		case "7bit", "8bit", "binary", "quoted-printable", "base64", "":
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTransferEncoding, encoding)
+		}
+	}
+
+	content = truncatedPartReader{r: content, truncated: &p.truncated}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		if p.opts.Lenient {
+			content = base64AlphabetReader{r: content}
+		}
+
+		return base64.NewDecoder(base64.StdEncoding, content), nil
+	case "quoted-printable":
+		return quotedprintable.NewReader(&softBreakReader{r: content, p: p}), nil
+	case "7bit", "8bit", "binary", "", "none":
+		return content, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding: %s", encoding)
+	}
+}
+
+// Options alter the behaviour of ParseWithOptions. The zero value parses the same way as Parse.
+type Options struct {
+	// CharsetReader, if non-nil, converts text in the given charset to UTF-8. It is used for text and html
+	// bodies, filenames and for RFC2047 encoded-words (including display names of addresses) in charsets
+	// the standard mime.WordDecoder does not support (e.g. GB2312, ISO-2022-JP). Html bodies without a
+	// charset parameter use the charset determined by charset.DetermineEncoding of golang.org/x/net, mostly
+	// the one declared by their <meta> tag, named the WHATWG way (e.g. windows-1252 for iso-8859-1). With a
+	// CharsetReader, that charset is also used when decoding an html body with its declared charset yields
+	// replacement characters (e.g. a us-ascii declaration for windows-1252 text); leave it nil to always trust
+	// the declared charset. Text in charsets CharsetReader fails on, or all text when it is nil, is decoded
+	// with the WHATWG encodings of golang.org/x/text.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+	// CharsetEncoder, if non-nil, converts UTF-8 text into the given charset. It is used by Email.TextBodyInCharset.
+	CharsetEncoder func(charset string, input io.Reader) (io.Reader, error)
+
+	// UnwrapNested makes the parser look for complete email messages wrapped as attachments (as done by
+	// some anti-virus gateways) and parse them into Email.SubMessages.
+	UnwrapNested bool
+
+	// BoundaryOverride maps boundaries declared in Content-Type headers to the boundaries actually used in
+	// the body. It allows salvaging messages from MTAs known to mangle the declared boundary.
+	BoundaryOverride map[string]string
+
+	// Lenient makes the parser tolerate malformed input instead of failing. Characters outside of the base64
+	// alphabet are dropped from base64 encoded content before decoding and trailing whitespace is trimmed
+	// from multipart boundaries. A part which still cannot be parsed (e.g. for an unknown transfer encoding or a
+	// malformed Content-Type) is skipped and the error is added to Email.Warnings, as is a broken multipart
+	// body, which keeps the parts read so far. Exceeding MaxBodySize or MaxAttachmentSize still fails. It cannot
+	// be combined with StrictErrors.
+	Lenient bool
+
+	// MaxHeaderSize limits the size in bytes of the header block of a message, protecting against messages
+	// with pathologically large headers. Exceeding it fails the parse with ErrHeadersTooLarge. Zero means no limit.
+	MaxHeaderSize int64
+
+	// MaxBodySize limits the decoded size in bytes of every text or html body part and of non-multipart content.
+	// MaxAttachmentSize does the same for every attachment, embedded file and vCard. Exceeding either fails the
+	// parse with ErrSizeLimitExceeded rather than truncating the content. Zero means no limit. Both limits apply
+	// to each part on its own, not to the total of all parts; limit the reader passed to ParseWithOptions (e.g.
+	// with io.LimitReader) to bound the size of the whole message.
+	MaxBodySize       int64
+	MaxAttachmentSize int64
+
+	// LazyAttachments defers reading and transfer-decoding attachments until Attachment.Data is first read,
+	// so attachments the caller never touches are never buffered. It requires the reader passed to
+	// ParseWithOptions to implement io.ReaderAt (e.g. *os.File, *bytes.Reader), as each attachment is read
+	// again from the source; other readers are decoded eagerly. The source must remain valid and unchanged
+	// until the attachments have been read, and the size of an attachment is unknown until it is read.
+	// Re-reading the source frees the attachments from the order of the parts: they may be read in any order,
+	// any number of times (once per Clone), and after the parse has moved on to the following parts.
+	LazyAttachments bool
+
+	// Strict makes the parser check the message for conformance with the MIME RFCs, reporting violations in
+	// Email.Warnings. It currently checks that multipart or base64/quoted-printable encoded messages carry a
+	// MIME-Version header (ErrMissingMIMEVersion) and that the Date header, if any, can be parsed
+	// (ErrMalformedDate). Unlike StrictErrors it never fails the parse, so it can be combined with Lenient.
+	Strict bool
+
+	// StrictErrors makes the parse fail on the first RFC violation rather than tolerating it, e.g. for
+	// compliance testing. Besides the checks of Strict it rejects
+	//   - multipart parts without a Content-Type header (ErrMissingContentType)
+	//   - transfer encodings other than 7bit, 8bit, binary, quoted-printable and base64 (ErrInvalidTransferEncoding)
+	//   - lines ending in a bare LF instead of CRLF anywhere in the message, epilogue included (ErrBareLineFeed)
+	//   - repeated Date, From, Sender, Reply-To, To, Cc, Bcc, Message-ID, In-Reply-To, References or Subject
+	//     headers (ErrDuplicateHeader)
+	// The errors may be wrapped, use errors.Is to tell them apart. StrictErrors cannot be combined with Lenient
+	// (ErrConflictingOptions).
+	StrictErrors bool
+
+	// Repair makes the parser recover multipart parts lacking a Content-Type instead of treating them as
+	// text/plain as RFC2045 says. Their content type is sniffed from the decoded content with
+	// http.DetectContentType. Text without a filename becomes part of the text or html body, anything else is
+	// added to the attachments, with an attachment disposition unless the part declares one.
+	Repair bool
+
+	// SMTPDotStuffed parses messages captured straight from the SMTP DATA command, whose lines starting
+	// with a dot were dot-stuffed by the sender (RFC5321 section 4.5.2) and which end with a "." line.
+	SMTPDotStuffed bool
+
+	// PreserveTrailingNewline keeps the final "\n" of text and html bodies, which is dropped by default, so
+	// that TextBody and HTMLBody hold exactly the decoded content (CRLF line endings included), e.g. of a diff.
+	PreserveTrailingNewline bool
+
+	// NormalizeNewlines converts the CRLF and bare CR line endings of text and html bodies to LF, so that the
+	// bodies look the same whatever the line endings of the message were.
+	NormalizeNewlines bool
+
+	// Uudecode makes the parser look for uuencoded files ("begin 644 name" ... "end" blocks) within text/plain
+	// bodies, as sent by legacy mailers. They are cut out of the body and added to the attachments. It's off by
+	// default as ordinary text may look like such a block.
+	Uudecode bool
+
+	// SniffContentType detects the actual content type of single part messages declared as
+	// application/octet-stream, e.g. a mislabeled PDF, and stores it in Email.DetectedContentType.
+	SniffContentType bool
+
+	// KeepRaw stores a copy of the whole message as read in Email.Raw, e.g. for auditing or re-signing. It
+	// doubles the memory needed for the message, as the copy is held in addition to the parsed content.
+	KeepRaw bool
+
+	// MaxDepth limits how deep multipart bodies may be nested before the parse fails with ErrMaxDepthExceeded,
+	// protecting against crafted messages. Zero means the default of 50.
+	MaxDepth int
+
+	// ChecksumHash creates the hash used for Attachment.Checksum and EmbeddedFile.Checksum, e.g. md5.New for
+	// compatibility with existing stores. Nil means SHA-256.
+	ChecksumHash func() hash.Hash
+}
+
+type parser struct {
+	opts Options
+
+	// ctx aborts the parse once done, it is nil when no context was given
+	ctx context.Context
+
+	// source and sourceStart locate the message in the reader passed to ParseWithOptions, set only for
+	// Options.LazyAttachments
+	source      io.ReaderAt
+	sourceStart int64
+
+	// path locates the multipart part being parsed within the message
+	path []partLocation
+
+	// truncated is set once a multipart part ended without its closing boundary
+	truncated bool
+
+	// nesting counts the enclosing messages when parsing an attached or wrapped message
+	nesting int
+
+	// structure is the root of Email.Structure, nodes holds the part being parsed at each nesting level of path
+	// and partNodes the node of every part entered
+	structure *Part
+	nodes     []*Part
+	partNodes map[*multipart.Part]*Part
+
+	warnings []error
+}
+
+// warn records a problem which doesn't stop the parse
+func (p *parser) warn(err error) {
+	p.warnings = append(p.warnings, err)
+}
+
+// partLocation identifies a part by its index within the multipart body delimited by boundary
+type partLocation struct {
+	boundary string
+	index    int
+}
+
+// maxDepth returns the multipart nesting limit, see Options.MaxDepth
+func (p *parser) maxDepth() int {
+	if p.opts.MaxDepth > 0 {
+		return p.opts.MaxDepth
+	}
+
+	return defaultMaxDepth
+}
+
+// tolerate records err as a warning and returns nil when Options.Lenient is set, so that the part which caused
+// it is skipped. Exceeding the size limits and a cancelled parse always fail.
+func (p *parser) tolerate(err error) error {
+	if err == nil || !p.opts.Lenient || errors.Is(err, ErrSizeLimitExceeded) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	p.warn(err)
+
+	return nil
+}
+
+// nextPart returns the next part of a multipart body unless the context of the parse is done. A body ending after
+// its first part but before the closing boundary ends the parts with io.EOF and marks the message as truncated.
+func (p *parser) nextPart(pmr *multipart.Reader, index int) (*multipart.Part, error) {
+	if p.ctx != nil {
+		if err := p.ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	part, err := pmr.NextRawPart()
+	if index > 0 && err != io.EOF && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+		// the part cut short may have been skipped unread, so its reader never reported the truncation
+		p.truncated = true
+		return nil, io.EOF
+	}
+
+	if err == nil && p.opts.StrictErrors && strings.TrimSpace(part.Header.Get("Content-Type")) == "" {
+		return nil, ErrMissingContentType
+	}
+
+	return part, err
+}
+
+// partError adds the position (numbered from 1), content type and filename of the part of a multipart body which
+// caused err to it, e.g. part 2 (application/pdf "invoice.pdf"): unknown encoding: foo. Errors of nested parts are
+// wrapped once per level. A done context is returned as it is.
+func (p *parser) partError(index int, part *multipart.Part, err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	description := mediaType(part.Header.Get("Content-Type"))
+	if description == "" {
+		description = "no Content-Type"
+	}
+
+	if filename := p.fileName(part); filename != "" {
+		description += fmt.Sprintf(" %q", filename)
+	}
+
+	return fmt.Errorf("part %d (%s): %w", index+1, description, err)
+}
+
+// strict reports whether the message is checked for RFC violations, see Options.Strict and Options.StrictErrors
+func (p *parser) strict() bool {
+	return p.opts.Strict || p.opts.StrictErrors
+}
+
+// violation returns err with Options.StrictErrors, otherwise it is recorded as a warning
+func (p *parser) violation(err error) error {
+	if p.opts.StrictErrors {
+		return err
+	}
+
+	p.warn(err)
+
+	return nil
+}
+
+// lineEndingReader notes whether the lines it reads end with a bare LF rather than CRLF, see Options.StrictErrors
+type lineEndingReader struct {
+	r      io.Reader
+	lastCR bool
+	bareLF bool
+}
+
+func (r *lineEndingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	for i := 0; i < n && !r.bareLF; i++ {
+		if b[i] == '\n' && !r.lastCR {
+			r.bareLF = true
+		}
+
+		r.lastCR = b[i] == '\r'
+	}
+
+	return n, err
+}
+
+// contextReader fails with ctx.Err() once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(b []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cr.r.Read(b)
+}
+
+// enterPart records that the part at index of the multipart body delimited by boundary is being parsed at
+// nesting level depth, and adds it to the Email.Structure tree
+func (p *parser) enterPart(depth int, boundary string, index int, part *multipart.Part) {
+	p.path = append(p.path[:depth], partLocation{boundary: boundary, index: index})
+
+	parent := p.structure
+	if depth > 0 {
+		parent = p.nodes[depth-1]
+	}
+
+	node := &Part{
+		ContentType: mediaType(part.Header.Get("Content-Type")),
+		Header:      cloneMIMEHeader(part.Header),
+	}
+
+	parent.Children = append(parent.Children, node)
+	p.nodes = append(p.nodes[:depth], node)
+
+	if p.partNodes == nil {
+		p.partNodes = make(map[*multipart.Part]*Part)
+	}
+	p.partNodes[part] = node
+}
+
+// node returns the Email.Structure node of part, or nil if part was not entered
+func (p *parser) node(part *multipart.Part) *Part {
+	return p.partNodes[part]
+}
+
+// mediaType returns the lowercased media type of a Content-Type header value, without its parameters
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
+
+// lazyPartReader reads the part at path from the source message again and transfer-decodes it on first Read
+type lazyPartReader struct {
+	p        *parser
+	source   io.ReaderAt
+	start    int64
+	path     []partLocation
+	encoding string
+
+	r   io.Reader
+	err error
+}
+
+func (lr *lazyPartReader) Read(b []byte) (int, error) {
+	if lr.r == nil && lr.err == nil {
+		lr.r, lr.err = lr.open()
+	}
+
+	if lr.err != nil {
+		return 0, lr.err
+	}
+
+	return lr.r.Read(b)
+}
+
+func (lr *lazyPartReader) open() (io.Reader, error) {
+	var source io.Reader = io.NewSectionReader(lr.source, lr.start, math.MaxInt64-lr.start)
+	if lr.p.opts.SMTPDotStuffed {
+		source = unstuffDots(source)
+	}
+
+	msg, err := mail.ReadMessage(stripBOM(source))
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = msg.Body
+	for _, loc := range lr.path {
+		mr := multipart.NewReader(r, loc.boundary)
+		var part *multipart.Part
+		for i := 0; i <= loc.index; i++ {
+			part, err = mr.NextRawPart()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		r = part
+	}
+
+	decoder, err := lr.p.newDecoder(r, lr.encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return limitSize(decoder, lr.p.opts.MaxAttachmentSize), nil
+}
+
+// sizeLimitReader fails with ErrSizeLimitExceeded once more than n bytes are read from r
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (lr *sizeLimitReader) Read(b []byte) (int, error) {
+	n, err := lr.r.Read(b)
+	lr.n -= int64(n)
+	if lr.n < 0 {
+		return n, ErrSizeLimitExceeded
+	}
+
+	return n, err
+}
+
+// limitSize limits r to limit bytes, zero meaning no limit
+func limitSize(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+
+	return &sizeLimitReader{r: r, n: limit}
+}
+
+// softBreakReader passes through quoted-printable encoded content and warns with ErrQuotedPrintableTruncated
+// when it ends with a soft line break. Multipart parts are delimited before they are decoded, so a properly
+// encoded line looking like the boundary is harmless, but a broken encoder leaving such a line unescaped cuts
+// the part short there, typically in the middle of a soft broken line.
+type softBreakReader struct {
+	r    io.Reader
+	p    *parser
+	last byte
+}
+
+func (sr *softBreakReader) Read(b []byte) (int, error) {
+	n, err := sr.r.Read(b)
+	for _, c := range b[:n] {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			sr.last = c
+		}
+	}
+
+	if err == io.EOF && sr.last == '=' {
+		sr.last = 0
+		sr.p.warn(ErrQuotedPrintableTruncated)
+	}
+
+	return n, err
+}
+
+// truncatedPartReader turns the io.ErrUnexpectedEOF returned by a multipart part missing its closing boundary
+// into io.EOF, so everything read up to the truncation is kept, and records the truncation
+type truncatedPartReader struct {
+	r         io.Reader
+	truncated *bool
+}
+
+func (tr truncatedPartReader) Read(b []byte) (int, error) {
+	n, err := tr.r.Read(b)
+	if err == io.ErrUnexpectedEOF {
+		*tr.truncated = true
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// base64AlphabetReader drops every byte outside of the base64 alphabet and padding, so stray characters
+// injected into base64 content don't abort decoding
+type base64AlphabetReader struct {
+	r io.Reader
+}
+
+func (br base64AlphabetReader) Read(b []byte) (int, error) {
+	for {
+		n, err := br.r.Read(b)
+
+		kept := 0
+		for _, c := range b[:n] {
+			if isBase64Char(c) {
+				b[kept] = c
+				kept++
+			}
+		}
+
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+func isBase64Char(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/' || c == '='
+}
+
+type headerParser struct {
+	header *mail.Header
+	err    error
+
+	// addressParser decodes encoded-words in display names the way decodeMimeSentence does
+	addressParser *mail.AddressParser
+}
+
+func (hp *headerParser) parseAddress(s string) (ma *mail.Address) {
+	if hp.err != nil {
+		return nil
+	}
+
+	if strings.Trim(s, " \n") != "" {
+		ma, hp.err = hp.addressParser.Parse(s)
+		return ma
+	}
+
+	return nil
+}
+
+func (hp *headerParser) parseAddressList(s string) (ma []*mail.Address) {
+	if hp.err != nil {
+		return
+	}
+
+	if strings.Trim(s, " \n") != "" {
+		ma, hp.err = hp.addressParser.ParseList(s)
+		return
+	}
+
+	return
+}
+
+// parseOptionalTime parses a date header, yielding the zero time when it is missing or malformed rather than failing
+// the whole parse over it. Obsolete RFC5322 forms are accepted as by mail.ParseDate.
+func parseOptionalTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+
+	formats := []string{
+		time.RFC1123Z,
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		time.RFC1123Z + " (MST)",
+		"Mon, 2 Jan 2006 15:04:05 -0700 (MST)",
+	}
+
+	for _, format := range formats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t
+		}
+	}
+
+	if t, err := mail.ParseDate(s); err == nil {
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (hp *headerParser) parseMessageId(s string) string {
+	if hp.err != nil {
+		return ""
+	}
+
+	return strings.Trim(s, "<> ")
+}
+
+// parseMessageIdList splits a list of message ids like the References header. The ids are taken from within
+// angle brackets, which also skips phrases some clients add to In-Reply-To and copes with ids not separated by
+// whitespace. A list without angle brackets is split on whitespace. An empty list yields nil.
+func (hp *headerParser) parseMessageIdList(s string) (result []string) {
+	if hp.err != nil {
+		return
+	}
+
+	for _, m := range messageIdRegexp.FindAllStringSubmatch(s, -1) {
+		if id := strings.TrimSpace(m[1]); id != "" {
+			result = append(result, id)
+		}
+	}
+
+	if result != nil || strings.Contains(s, "<") {
+		return
+	}
+
+	for _, p := range strings.Fields(s) {
+		result = append(result, hp.parseMessageId(p))
+	}
+
+	return
+}
+
+// Attachment with filename, content type and data (as a io.Reader). Data is backed by an in-memory buffer
+// (unless parsed with Options.LazyAttachments), use Bytes to read it repeatedly. Attachments sent without a
+// filename are named after their position and content type, e.g. attachment-3.pdf.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        io.Reader
+
+	// ContentTypeParams holds the parameters of the Content-Type header, e.g. charset or name
+	ContentTypeParams map[string]string
+
+	// Checksum is the hex encoded hash (SHA-256 unless set otherwise by Options.ChecksumHash) of the decoded
+	// content. It is empty for attachments parsed with Options.LazyAttachments.
+	Checksum string
+
+	// CID is the Content-ID of the attachment, if any, by which the html body may reference it
+	CID string
+
+	// Disposition is the raw Content-Disposition type and DispositionParams its parameters
+	Disposition       string
+	DispositionParams map[string]string
+
+	// Duration of audio/video attachments taken from the Content-Duration header
+	Duration time.Duration
+
+	// ResourceFork holds the AppleSingle resource fork of attachments sent as multipart/appledouble
+	ResourceFork io.Reader
+
+	// MacType and MacCreator are the classic Mac OS file type and creator codes (e.g. "PDF ", "CARO") taken
+	// from the RFC1740 x-mac-type and x-mac-creator Content-Type parameters
+	MacType    string
+	MacCreator string
+
+	// Header holds all the MIME headers of the attachment part, e.g. Content-Description or custom X- headers
+	Header textproto.MIMEHeader
+
+	// Message is the parsed content of a message/rfc822 attachment (a forwarded or bounced message), nil for
+	// other attachments or if the attached message could not be parsed
+	Message *Email
+}
+
+// ContentIDResource is a part of the email referenced by its Content-ID, stored either as an embedded file or
+// as an attachment
+type ContentIDResource interface {
+	// Bytes returns the decoded data of the part. It may be called repeatedly.
+	Bytes() ([]byte, error)
+
+	// ContentType returns the content type of the part
+	ContentType() string
+}
+
+type contentIDResource struct {
+	contentType string
+	data        *io.Reader
+}
+
+func (r contentIDResource) Bytes() ([]byte, error) {
+	return bufferReader(r.data)
+}
+
+func (r contentIDResource) ContentType() string {
+	return r.contentType
+}
+
+// ImageRefKind tells where the data of an image referenced by the html body comes from
+type ImageRefKind int
+
+const (
+	// ImageRefRemote images are loaded from an URL
+	ImageRefRemote ImageRefKind = iota
+	// ImageRefCID images reference an embedded file through a cid: URL
+	ImageRefCID
+	// ImageRefData images are inlined as a data: URI
+	ImageRefData
+)
+
+// ImageRef is an image referenced by the html body. Tracker is set for remote images sized one pixel or less,
+// which are most likely tracking pixels.
+type ImageRef struct {
+	Src     string
+	Kind    ImageRefKind
+	CID     string
+	Tracker bool
+}
+
+// VCard is a text/vcard attachment with the contact's full name and email addresses extracted for convenience
+type VCard struct {
+	Filename string
+	Raw      []byte
+
+	FullName string
+	Emails   []string
+}
+
+// Part is a node of the MIME structure of a message, see Email.Structure. Multipart parts have Children, the
+// others hold their transfer-decoded Content, which is nil for parts the parser didn't read (e.g. attachments
+// loaded lazily or parts of unknown types).
+type Part struct {
+	ContentType string
+	Header      textproto.MIMEHeader
+	Children    []*Part
+	Content     []byte
+}
+
+// PartKind tells which field of Email a ParsedPart comes from
+type PartKind int
+
+const (
+	PartTextBody PartKind = iota
+	PartHTMLBody
+	PartAttachment
+	PartEmbeddedFile
+	PartSubMessage
+)
+
+// ParsedPart is a uniform view of the bodies, attachments, embedded files and sub messages visited by Email.Walk.
+// Body is set for text and html bodies, otherwise only the pointer matching Kind is set.
+type ParsedPart struct {
+	Kind        PartKind
+	ContentType string
+
+	Body         string
+	Attachment   *Attachment
+	EmbeddedFile *EmbeddedFile
+	Message      *Email
+}
+
+// EncryptedContent is the encrypted body of a multipart/encrypted (e.g. PGP/MIME) or S/MIME enveloped-data
+// message. It is not decrypted.
+type EncryptedContent struct {
+	// Protocol is the protocol parameter of multipart/encrypted (e.g. application/pgp-encrypted) or
+	// application/pkcs7-mime for S/MIME
+	Protocol string
+
+	// Control is the control part of multipart/encrypted, e.g. "Version: 1" for PGP/MIME. It is nil for S/MIME.
+	Control []byte
+
+	// Data is the transfer-decoded encrypted data
+	Data []byte
+}
+
+// Signature is the signature of a RFC1847 multipart/signed message, e.g. PGP/MIME or S/MIME. It is not verified.
+type Signature struct {
+	// Protocol and MICAlg are the protocol (e.g. application/pgp-signature) and micalg (e.g. pgp-sha256)
+	// parameters of the multipart/signed Content-Type
+	Protocol string
+	MICAlg   string
+
+	// SignedContent is the signed part, headers included, exactly as received, i.e. the data to verify
+	SignedContent []byte
+
+	// Data is the transfer-decoded signature part
+	Data []byte
+}
+
+// DeliveryReport is a parsed multipart/report message, such as a bounce or a delivery status notification
+type DeliveryReport struct {
+	// ReportingMTA and ArrivalDate are the per-message fields of the message/delivery-status part
+	ReportingMTA string
+	ArrivalDate  time.Time
+
+	// Recipients holds the status of the delivery to each recipient of the original message
+	Recipients []RecipientStatus
+
+	// Original is the returned original message when it was included as message/rfc822, OriginalHeader is its
+	// header, which may be all that was returned (as text/rfc822-headers)
+	Original       *Email
+	OriginalHeader mail.Header
+}
+
+// RecipientStatus is a group of per-recipient fields of a RFC3464 message/delivery-status part. Address types
+// (e.g. "rfc822;") and diagnostic types (e.g. "smtp;") are stripped from the values.
+type RecipientStatus struct {
+	FinalRecipient    string
+	OriginalRecipient string
+
+	// Action is one of failed, delayed, delivered, relayed or expanded
+	Action string
+
+	// Status is the enhanced status code, e.g. 5.1.1
+	Status string
+
+	DiagnosticCode string
+	RemoteMTA      string
+}
+
+// SpamStatus is the parsed SpamAssassin X-Spam-Status header
+type SpamStatus struct {
+	IsSpam   bool
+	Score    float64
+	Required float64
+	Tests    []string
+}
+
+// ReceivedHeader is a parsed Received trace header, i.e. one hop of the message on its way to the recipient.
+// Fields missing from the header are empty, Raw always holds the whole header value.
+type ReceivedHeader struct {
+	From string
+	By   string
+	With string
+	ID   string
+	For  string
+	Date time.Time
+
+	Raw string
+}
+
+// PartMeta describes a MIME part before its content is decoded
+type PartMeta struct {
+	Filename    string
+	ContentType string
+	Header      textproto.MIMEHeader
+}
+
+// Bytes returns the decoded data of the attachment. It may be called repeatedly, Data is reset to the start of
+// the content afterwards.
+func (a *Attachment) Bytes() ([]byte, error) {
+	return bufferReader(&a.Data)
+}
+
+// EmbeddedFile with content id, content type and data (as a io.Reader). Data is backed by an in-memory buffer,
+// use Bytes to read it repeatedly.
+type EmbeddedFile struct {
+	CID         string
+	Filename    string
+	ContentType string
+	Data        io.Reader
+
+	// Disposition is the raw Content-Disposition type and DispositionParams its parameters
+	Disposition       string
+	DispositionParams map[string]string
+
+	// Header holds all the MIME headers of the embedded part. It is nil for files embedded as data URIs.
+	Header textproto.MIMEHeader
+
+	// Checksum is the hex encoded hash of the decoded content, see Attachment.Checksum. It is empty for files
+	// embedded as data URIs.
+	Checksum string
+}
+
+// Bytes returns the decoded data of the embedded file. It may be called repeatedly, Data is reset to the start
+// of the content afterwards.
+func (ef *EmbeddedFile) Bytes() ([]byte, error) {
+	return bufferReader(&ef.Data)
+}
+
+// Email with fields for all the headers defined in RFC5322 with it's attachments and
+type Email struct {
+	Header mail.Header
+
+	Subject    string
+	Sender     *mail.Address
+	From       []*mail.Address
+	ReplyTo    []*mail.Address
+	To         []*mail.Address
+	Cc         []*mail.Address
+	Bcc        []*mail.Address
+	Date       time.Time
+	MessageID  string
+	InReplyTo  []string
+	References []string
+
+	ResentFrom      []*mail.Address
+	ResentSender    *mail.Address
+	ResentTo        []*mail.Address
+	ResentDate      time.Time
+	ResentCc        []*mail.Address
+	ResentBcc       []*mail.Address
+	ResentMessageID string
+
+	// Expires and ReplyBy are taken from the Expires and Reply-By headers, they are zero when missing or malformed
+	Expires time.Time
+	ReplyBy time.Time
+
+	// Sensitivity is the normalized RFC2156 Sensitivity header, see the Sensitivity constants
+	Sensitivity string
+
+	// ListID and ListDescription are the identifier and description of the mailing list from the RFC2919
+	// List-Id header, e.g. "golang-nuts.googlegroups.com" and "Go Nuts"
+	ListID          string
+	ListDescription string
+
+	// ListPost, ListHelp and ListArchive are the URIs of the RFC2369 List-Post, List-Help and List-Archive headers
+	ListPost    []string
+	ListHelp    []string
+	ListArchive []string
+
+	// ListSubscribe, ListUnsubscribe and ListOwner are the URIs of the RFC2369 List-Subscribe, List-Unsubscribe
+	// and List-Owner headers, e.g. ["mailto:leave@example.com", "https://example.com/unsubscribe?id=1"]
+	ListSubscribe   []string
+	ListUnsubscribe []string
+	ListOwner       []string
+
+	// ListUnsubscribePost is the RFC8058 List-Unsubscribe-Post header, "List-Unsubscribe=One-Click" when the
+	// https URI of ListUnsubscribe supports one-click unsubscription with a POST request
+	ListUnsubscribePost string
+
+	// SpamStatus is the verdict of SpamAssassin from the X-Spam-Status header, nil if the email wasn't scanned
+	SpamStatus *SpamStatus
+
+	// Received holds the Received headers in the order they appear, i.e. the most recent hop first
+	Received []ReceivedHeader
+
+	// Report is the delivery report of a multipart/report message (e.g. a bounce), nil for other messages
+	Report *DeliveryReport
+
+	// Signature is set for multipart/signed messages, whose signed part is parsed into the fields above
+	Signature *Signature
+
+	// Encrypted holds the encrypted data of encrypted messages, see IsEncrypted
+	Encrypted *EncryptedContent
+
+	ContentType string
+	Content     io.Reader
+
+	// DetectedContentType is the content type of Content as sniffed by http.DetectContentType (e.g.
+	// "application/pdf") when parsed with Options.SniffContentType, empty otherwise
+	DetectedContentType string
+
+	// TransferEncoding is the lowercased Content-Transfer-Encoding of the top-level message, e.g. base64 for
+	// a single part message whose body was base64 encoded. It's empty when the header is missing.
+	TransferEncoding string
+
+	// IsEncrypted is set for S/MIME enveloped-data and multipart/encrypted messages, see Encrypted. SMIMEType
+	// holds the smime-type parameter (e.g. enveloped-data, signed-data) and SMIMEData the decoded PKCS#7 bytes.
+	IsEncrypted bool
+	SMIMEType   string
+	SMIMEData   io.Reader
+
+	HTMLBody string
+	TextBody string
+
+	// HTMLBodies and TextBodies hold each html and text body part separately in the order they appear in the
+	// message, HTMLBody and TextBody being their concatenation
+	HTMLBodies []string
+	TextBodies []string
+
+	// HTMLBodyCharset and TextBodyCharset hold the charset declared by the first html and text body part, see
+	// Options.CharsetReader for html bodies declaring none
+	HTMLBodyCharset string
+	TextBodyCharset string
+
+	// TextBodyCharsets holds the charset declared by each text body part, one per TextBodies entry
+	TextBodyCharsets []string
+
+	// Attachments and EmbeddedFiles are nil when the email has none, use AttachmentCount and
+	// EmbeddedFileCount when you don't care about the difference between nil and empty
+	Attachments   []Attachment
+	EmbeddedFiles []EmbeddedFile
+
+	// VCards holds text/vcard and text/x-vcard parts, they are not included in Attachments
+	VCards []VCard
+
+	// Truncated is set when a multipart body ended without its closing boundary. Everything up to the
+	// truncation is kept.
+	Truncated bool
+
+	// Raw holds the message exactly as read when parsed with Options.KeepRaw, nil otherwise
+	Raw []byte
+
+	// SubMessages holds emails found wrapped inside attachments when Options.UnwrapNested is set
+	SubMessages []Email
+
+	// Structure is the MIME part tree of the message, its root standing for the message itself. The fields
+	// above are collected while the tree is built and keep the order and nesting of the parts flattened.
+	Structure *Part
+
+	// ContentBase is the base URL for relative links in the html body from the RFC2110 Content-Base header,
+	// see ResolveHTMLLinks
+	ContentBase string
+
+	// MIMEVersion is the value of the MIME-Version header, normally "1.0"
+	MIMEVersion string
+
+	// Warnings lists problems found in the message which didn't stop the parse, e.g. the conformance
+	// violations reported by Options.Strict or the parts skipped by Options.Lenient
+	Warnings []error
+
+	charsetEncoder func(charset string, input io.Reader) (io.Reader, error)
+}
+
+// Clone returns a deep copy of the email that can be consumed independently of the original. The data of
+// attachments, embedded files and content is buffered and both the clone and the original get fresh readers
+// positioned at the start of the remaining data.
+func (e *Email) Clone() Email {
+	c := *e
+
+	c.Header = cloneHeader(e.Header)
+	c.Sender = cloneAddress(e.Sender)
+	c.From = cloneAddressList(e.From)
+	c.ReplyTo = cloneAddressList(e.ReplyTo)
+	c.To = cloneAddressList(e.To)
+	c.Cc = cloneAddressList(e.Cc)
+	c.Bcc = cloneAddressList(e.Bcc)
+	c.InReplyTo = cloneStrings(e.InReplyTo)
+	c.References = cloneStrings(e.References)
+	c.HTMLBodies = cloneStrings(e.HTMLBodies)
+	c.TextBodies = cloneStrings(e.TextBodies)
+	c.TextBodyCharsets = cloneStrings(e.TextBodyCharsets)
+	c.ResentFrom = cloneAddressList(e.ResentFrom)
+	c.ResentSender = cloneAddress(e.ResentSender)
+	c.ResentTo = cloneAddressList(e.ResentTo)
+	c.ResentCc = cloneAddressList(e.ResentCc)
+	c.ResentBcc = cloneAddressList(e.ResentBcc)
+	c.Content = cloneReader(&e.Content)
+	c.SMIMEData = cloneReader(&e.SMIMEData)
+	c.ListPost = cloneStrings(e.ListPost)
+	c.ListHelp = cloneStrings(e.ListHelp)
+	c.ListArchive = cloneStrings(e.ListArchive)
+	c.ListSubscribe = cloneStrings(e.ListSubscribe)
+	c.ListUnsubscribe = cloneStrings(e.ListUnsubscribe)
+	c.ListOwner = cloneStrings(e.ListOwner)
+	c.Received = append([]ReceivedHeader(nil), e.Received...)
+
+	if e.Raw != nil {
+		c.Raw = append([]byte(nil), e.Raw...)
+	}
+
+	c.Structure = clonePart(e.Structure)
+
+	if e.Encrypted != nil {
+		encrypted := *e.Encrypted
+		encrypted.Control = append([]byte(nil), e.Encrypted.Control...)
+		encrypted.Data = append([]byte(nil), e.Encrypted.Data...)
+		c.Encrypted = &encrypted
+	}
+
+	if e.Signature != nil {
+		signature := *e.Signature
+		signature.SignedContent = append([]byte(nil), e.Signature.SignedContent...)
+		signature.Data = append([]byte(nil), e.Signature.Data...)
+		c.Signature = &signature
+	}
+
+	if e.Report != nil {
+		report := *e.Report
+		report.Recipients = append([]RecipientStatus(nil), e.Report.Recipients...)
+		report.OriginalHeader = cloneHeader(e.Report.OriginalHeader)
+		if e.Report.Original != nil {
+			original := e.Report.Original.Clone()
+			report.Original = &original
+		}
+
+		c.Report = &report
+	}
+
+	if e.SpamStatus != nil {
+		ss := *e.SpamStatus
+		ss.Tests = cloneStrings(e.SpamStatus.Tests)
+		c.SpamStatus = &ss
+	}
+
+	if e.Attachments != nil {
+		c.Attachments = make([]Attachment, len(e.Attachments))
+		for i := range e.Attachments {
+			at := &e.Attachments[i]
+			c.Attachments[i] = *at
+			c.Attachments[i].Data = cloneReader(&at.Data)
+			c.Attachments[i].ContentTypeParams = cloneParams(at.ContentTypeParams)
+			c.Attachments[i].DispositionParams = cloneParams(at.DispositionParams)
+			c.Attachments[i].ResourceFork = cloneReader(&at.ResourceFork)
+			c.Attachments[i].Header = cloneMIMEHeader(at.Header)
+
+			if at.Message != nil {
+				msg := at.Message.Clone()
+				c.Attachments[i].Message = &msg
+			}
+		}
+	}
+
+	if e.EmbeddedFiles != nil {
+		c.EmbeddedFiles = make([]EmbeddedFile, len(e.EmbeddedFiles))
+		for i := range e.EmbeddedFiles {
+			ef := &e.EmbeddedFiles[i]
+			c.EmbeddedFiles[i] = *ef
+			c.EmbeddedFiles[i].Data = cloneReader(&ef.Data)
+			c.EmbeddedFiles[i].DispositionParams = cloneParams(ef.DispositionParams)
+			c.EmbeddedFiles[i].Header = cloneMIMEHeader(ef.Header)
+		}
+	}
+
+	if e.VCards != nil {
+		c.VCards = make([]VCard, len(e.VCards))
+		for i, vc := range e.VCards {
+			c.VCards[i] = vc
+			c.VCards[i].Raw = append([]byte{}, vc.Raw...)
+			c.VCards[i].Emails = cloneStrings(vc.Emails)
+		}
+	}
+
+	if e.Warnings != nil {
+		c.Warnings = append([]error{}, e.Warnings...)
+	}
+
+	if e.SubMessages != nil {
+		c.SubMessages = make([]Email, len(e.SubMessages))
+		for i := range e.SubMessages {
+			c.SubMessages[i] = e.SubMessages[i].Clone()
+		}
+	}
+
+	return c
+}
+
+// bufferReader reads the whole content of *r, rewinding it first if it is an in-memory buffer, and replaces *r
+// with a fresh reader over the content
+func bufferReader(r *io.Reader) ([]byte, error) {
+	if *r == nil {
+		return nil, nil
+	}
+
+	if br, ok := (*r).(*bytes.Reader); ok {
+		if _, err := br.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := io.ReadAll(*r)
+	if err != nil {
+		return nil, err
+	}
+
+	*r = bytes.NewReader(b)
+
+	return b, nil
+}
+
+// cloneReader buffers the remaining data of *r, replaces *r with a fresh reader over it and returns another one
+func cloneReader(r *io.Reader) io.Reader {
+	if *r == nil {
+		return nil
+	}
+
+	b, _ := io.ReadAll(*r)
+	*r = bytes.NewReader(b)
+
+	return bytes.NewReader(b)
+}
+
+func clonePart(part *Part) *Part {
+	if part == nil {
+		return nil
+	}
+
+	c := &Part{
+		ContentType: part.ContentType,
+		Header:      cloneMIMEHeader(part.Header),
+	}
+
+	if part.Content != nil {
+		c.Content = append([]byte{}, part.Content...)
+	}
+
+	for _, child := range part.Children {
+		c.Children = append(c.Children, clonePart(child))
+	}
+
+	return c
+}
+
+func cloneMIMEHeader(h textproto.MIMEHeader) textproto.MIMEHeader {
+	if h == nil {
+		return nil
+	}
+
+	c := make(textproto.MIMEHeader, len(h))
+	for k, v := range h {
+		c[k] = cloneStrings(v)
+	}
+
+	return c
+}
+
+func cloneHeader(h mail.Header) mail.Header {
+	if h == nil {
+		return nil
+	}
+
+	c := make(mail.Header, len(h))
+	for k, v := range h {
+		c[k] = cloneStrings(v)
+	}
+
+	return c
+}
+
+func cloneParams(params map[string]string) map[string]string {
+	if params == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(params))
+	for k, v := range params {
+		c[k] = v
+	}
+
+	return c
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append([]string{}, s...)
+}
+
+func cloneAddress(a *mail.Address) *mail.Address {
+	if a == nil {
+		return nil
+	}
+
+	c := *a
+	return &c
+}
+
+func cloneAddressList(al []*mail.Address) []*mail.Address {
+	if al == nil {
+		return nil
+	}
+
+	c := make([]*mail.Address, len(al))
+	for i, a := range al {
+		c[i] = cloneAddress(a)
+	}
+
+	return c
+}
+
+// AttachmentCount returns the number of attachments of the email
+func (e *Email) AttachmentCount() int {
+	return len(e.Attachments)
+}
+
+// AttachmentsByType returns the attachments whose content type starts with prefix, e.g. "image/" or "application/pdf".
+// The comparison is case-insensitive and ignores content type parameters.
+func (e *Email) AttachmentsByType(prefix string) (attachments []Attachment) {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	for _, at := range e.Attachments {
+		contentType := strings.ToLower(strings.TrimSpace(strings.Split(at.ContentType, ";")[0]))
+		if strings.HasPrefix(contentType, prefix) {
+			attachments = append(attachments, at)
+		}
+	}
+
+	return
+}
+
+// ByContentID returns the embedded file or attachment with the given Content-ID, or nil if there is none. The cid
+// may be given with or without angle brackets or a cid: URL scheme, as found in the html body. Embedded files take
+// precedence over attachments.
+func (e *Email) ByContentID(cid string) ContentIDResource {
+	cid = strings.Trim(strings.TrimPrefix(strings.TrimSpace(cid), "cid:"), "<>")
+	if cid == "" {
+		return nil
+	}
+
+	for i := range e.EmbeddedFiles {
+		if e.EmbeddedFiles[i].CID == cid {
+			return contentIDResource{contentType: e.EmbeddedFiles[i].ContentType, data: &e.EmbeddedFiles[i].Data}
+		}
+	}
+
+	for i := range e.Attachments {
+		if e.Attachments[i].CID == cid {
+			return contentIDResource{contentType: e.Attachments[i].ContentType, data: &e.Attachments[i].Data}
+		}
+	}
+
+	return nil
+}
+
+// AttachmentByName returns the first attachment whose decoded filename matches name case-insensitively. The
+// returned attachment shares its Data with the one in Attachments.
+func (e *Email) AttachmentByName(name string) (Attachment, bool) {
+	for _, at := range e.Attachments {
+		if strings.EqualFold(at.Filename, name) {
+			return at, true
+		}
+	}
+
+	return Attachment{}, false
+}
+
+// EmbeddedFileByCID returns the embedded file with the given Content-ID, which may be given with or without angle
+// brackets or a cid: URL scheme. The returned file shares its Data with the one in EmbeddedFiles.
+func (e *Email) EmbeddedFileByCID(cid string) (EmbeddedFile, bool) {
+	cid = strings.Trim(strings.TrimPrefix(strings.TrimSpace(cid), "cid:"), "<>")
+	if cid == "" {
+		return EmbeddedFile{}, false
+	}
+
+	for _, ef := range e.EmbeddedFiles {
+		if ef.CID == cid {
+			return ef, true
+		}
+	}
+
+	return EmbeddedFile{}, false
+}
+
+// InlineImages maps the Content-IDs (without angle brackets) of the embedded files to the files, for resolving
+// cid: URLs of the html body. All embedded files are included as some senders label images application/octet-stream.
+// Of embedded files sharing a Content-ID, the first one is kept, as it's the one a mail client would display.
+func (e *Email) InlineImages() map[string]EmbeddedFile {
+	images := map[string]EmbeddedFile{}
+	for _, ef := range e.EmbeddedFiles {
+		if _, ok := images[ef.CID]; !ok && ef.CID != "" {
+			images[ef.CID] = ef
+		}
+	}
+
+	return images
+}
+
+// EmbeddedFileCount returns the number of embedded files of the email
+func (e *Email) EmbeddedFileCount() int {
+	return len(e.EmbeddedFiles)
+}
+
+// RecipientCount returns the number of unique To, Cc and Bcc recipients. Addresses are compared case-insensitively
+// and members of group syntax (e.g. "Team: a@example.com, b@example.com;") are counted individually.
+func (e *Email) RecipientCount() int {
+	seen := map[string]bool{}
+	for _, list := range [][]*mail.Address{e.To, e.Cc, e.Bcc} {
+		for _, a := range list {
+			seen[strings.ToLower(a.Address)] = true
+		}
+	}
+
+	return len(seen)
+}
+
+// ContentLanguages returns the languages listed in the Content-Language header, e.g. ["en-US", "de"]. Quality values
+// (";q=0.8") are dropped and the order of the header is kept.
+func (e *Email) ContentLanguages() (languages []string) {
+	for _, l := range strings.Split(e.Header.Get("Content-Language"), ",") {
+		l = strings.TrimSpace(strings.Split(l, ";")[0])
+		if l != "" {
+			languages = append(languages, l)
+		}
+	}
+
+	return
+}
+
+// DeliveredTo returns the addresses of all the Delivered-To headers in the order they appear in the message, i.e.
+// the outermost (last) delivery first. Unparsable addresses are skipped.
+func (e *Email) DeliveredTo() (addresses []*mail.Address) {
+	for _, v := range e.Header["Delivered-To"] {
+		if a, err := mail.ParseAddress(v); err == nil {
+			addresses = append(addresses, a)
+		}
+	}
+
+	return
+}
+
+// OriginalTo returns the envelope recipient recorded in the X-Original-To header, or nil when it's missing or unparsable
+func (e *Email) OriginalTo() *mail.Address {
+	a, err := mail.ParseAddress(e.Header.Get("X-Original-To"))
+	if err != nil {
+		return nil
+	}
+
+	return a
+}
+
+// ReturnPath returns the address of the Return-Path header added on final delivery, with the angle brackets
+// trimmed. It is empty when the header is missing or holds the null reverse-path <> of a bounce.
+func (e *Email) ReturnPath() string {
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(e.Header.Get("Return-Path")), "<"), ">"))
+}
+
+// BuildReplyHeaders makes e a reply to original following the RFC5322 threading rules. In-Reply-To is set to the
+// original's Message-ID and References to the original's References (or its In-Reply-To when it has no References)
+// followed by its Message-ID. To keep the header length sane, References is capped at maxReplyReferences ids by
+// dropping ids after the thread root. The resulting ids are stored in e.InReplyTo and e.References and returned
+// as In-Reply-To and References header fields.
+func (e *Email) BuildReplyHeaders(original Email) mail.Header {
+	references := cloneStrings(original.References)
+	if len(references) == 0 && len(original.InReplyTo) == 1 {
+		references = cloneStrings(original.InReplyTo)
+	}
+
+	e.InReplyTo = nil
+	if original.MessageID != "" {
+		e.InReplyTo = []string{original.MessageID}
+		references = append(references, original.MessageID)
+	}
+
+	if len(references) > maxReplyReferences {
+		references = append(references[:1], references[len(references)-maxReplyReferences+1:]...)
+	}
+
+	e.References = references
+
+	header := mail.Header{}
+	if len(e.InReplyTo) > 0 {
+		header["In-Reply-To"] = []string{formatMessageIdList(e.InReplyTo)}
+	}
+
+	if len(e.References) > 0 {
+		header["References"] = []string{formatMessageIdList(e.References)}
+	}
+
+	return header
+}
+
+func formatMessageIdList(ids []string) string {
+	formatted := make([]string, len(ids))
+	for i, id := range ids {
+		formatted[i] = "<" + id + ">"
+	}
+
+	return strings.Join(formatted, " ")
+}
+
+// RootMessageID returns the message id of the root of the thread the email belongs to. That is the first
+// entry of References, falling back to In-Reply-To and finally to the email's own Message-ID.
+func (e *Email) RootMessageID() string {
+	if len(e.References) > 0 {
+		return e.References[0]
+	}
+
+	if len(e.InReplyTo) > 0 {
+		return e.InReplyTo[0]
+	}
+
+	return e.MessageID
+}
+
+// BestAlternative returns the first non-empty body matching the content types in accept, which are given in the
+// order of preference, e.g. []string{"text/html", "text/plain"}. Wildcards like "text/*" and "*/*" match the
+// html body before the text body. Empty strings are returned when no body matches.
+func (e *Email) BestAlternative(accept []string) (content string, contentType string) {
+	bodies := []struct {
+		contentType string
+		content     string
+	}{
+		{contentTypeTextHtml, e.HTMLBody},
+		{contentTypeTextPlain, e.TextBody},
+	}
+
+	for _, a := range accept {
+		a = strings.ToLower(strings.TrimSpace(a))
+		for _, body := range bodies {
+			if body.content != "" && mediaTypeMatches(a, body.contentType) {
+				return body.content, body.contentType
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// mediaTypeMatches reports whether contentType matches pattern, which may be a "type/*" or "*/*" wildcard
+func mediaTypeMatches(pattern string, contentType string) bool {
+	if pattern == "*/*" || pattern == contentType {
+		return true
+	}
+
+	return strings.HasSuffix(pattern, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))
+}
+
+// HTMLBodyImages lists the images referenced by <img src> in the html body, in document order
+func (e *Email) HTMLBodyImages() (images []ImageRef) {
+	z := html.NewTokenizer(strings.NewReader(e.HTMLBody))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		tag := z.Token()
+		if tag.DataAtom != atom.Img {
+			continue
+		}
+
+		attrs := htmlAttributes(tag)
+
+		src, ok := attrs["src"]
+		if !ok {
+			continue
+		}
+
+		image := ImageRef{Src: src}
+		switch lower := strings.ToLower(src); {
+		case strings.HasPrefix(lower, "cid:"):
+			image.Kind = ImageRefCID
+			image.CID = strings.Trim(src[len("cid:"):], "<>")
+		case strings.HasPrefix(lower, "data:"):
+			image.Kind = ImageRefData
+		default:
+			image.Kind = ImageRefRemote
+			image.Tracker = isPixelSize(attrs["width"]) && isPixelSize(attrs["height"])
+		}
+
+		images = append(images, image)
+	}
+}
+
+// htmlAttributes maps the attributes of a tag by their lower-cased name, the first occurrence of a repeated
+// attribute winning as it does in browsers
+func htmlAttributes(tag html.Token) map[string]string {
+	attrs := map[string]string{}
+	for _, attr := range tag.Attr {
+		if _, ok := attrs[attr.Key]; ok {
+			continue
+		}
+
+		attrs[attr.Key] = attr.Val
+	}
+
+	return attrs
+}
+
+// ExtractDataURIs decodes the RFC2397 data: URIs found in src and href attributes of the html body (e.g.
+// <img src="data:image/png;base64,...">) into embedded files. Since they have no Content-ID, they are given synthetic
+// CIDs of the form data-uri-N@parsemail, numbered from 1 in document order. Malformed data URIs are skipped.
+func (e *Email) ExtractDataURIs() (files []EmbeddedFile) {
+	z := html.NewTokenizer(strings.NewReader(e.HTMLBody))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		for _, attr := range z.Token().Attr {
+			if attr.Key != "src" && attr.Key != "href" {
+				continue
+			}
+
+			if ef, ok := decodeDataURI(strings.TrimSpace(attr.Val)); ok {
+				ef.CID = fmt.Sprintf("data-uri-%d@parsemail", len(files)+1)
+				files = append(files, ef)
+			}
+		}
+	}
+}
+
+// decodeDataURI decodes an RFC2397 data: URI into an embedded file without a CID
+func decodeDataURI(uri string) (EmbeddedFile, bool) {
+	if len(uri) < len("data:") || !strings.EqualFold(uri[:len("data:")], "data:") {
+		return EmbeddedFile{}, false
+	}
+
+	uri = uri[len("data:"):]
+	comma := strings.Index(uri, ",")
+	if comma < 0 {
+		return EmbeddedFile{}, false
+	}
+
+	mediaType, data := uri[:comma], uri[comma+1:]
+
+	var decoded []byte
+	var err error
+	if strings.HasSuffix(strings.ToLower(mediaType), ";base64") {
+		mediaType = mediaType[:len(mediaType)-len(";base64")]
+		decoded, err = base64.StdEncoding.DecodeString(data)
+	} else {
+		var unescaped string
+		unescaped, err = url.PathUnescape(data)
+		decoded = []byte(unescaped)
+	}
+
+	if err != nil {
+		return EmbeddedFile{}, false
+	}
+
+	if mediaType == "" {
+		mediaType = "text/plain;charset=US-ASCII"
+	}
+
+	return EmbeddedFile{ContentType: mediaType, Data: bytes.NewReader(decoded)}, true
+}
+
+// isPixelSize reports whether an html width/height attribute is at most one pixel
+func isPixelSize(s string) bool {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(s), "px"))
+	return err == nil && n <= 1
+}
+
+// Walk visits the text body, html body, attachments, embedded files and sub messages of the email in this order,
+// descending into sub messages after visiting them. Empty bodies are not visited. If fn returns SkipPart for a
+// sub message its contents are skipped, SkipAll stops the walk and any other error stops the walk and is returned.
+func (e *Email) Walk(fn func(p *ParsedPart) error) error {
+	err := e.walk(fn)
+	if err == SkipAll {
+		return nil
+	}
+
+	return err
+}
+
+func (e *Email) walk(fn func(p *ParsedPart) error) error {
+	parts := []*ParsedPart{}
+	if e.TextBody != "" {
+		parts = append(parts, &ParsedPart{Kind: PartTextBody, ContentType: contentTypeTextPlain, Body: e.TextBody})
+	}
+
+	if e.HTMLBody != "" {
+		parts = append(parts, &ParsedPart{Kind: PartHTMLBody, ContentType: contentTypeTextHtml, Body: e.HTMLBody})
+	}
+
+	for i := range e.Attachments {
+		parts = append(parts, &ParsedPart{Kind: PartAttachment, ContentType: e.Attachments[i].ContentType, Attachment: &e.Attachments[i]})
+	}
+
+	for i := range e.EmbeddedFiles {
+		parts = append(parts, &ParsedPart{Kind: PartEmbeddedFile, ContentType: e.EmbeddedFiles[i].ContentType, EmbeddedFile: &e.EmbeddedFiles[i]})
+	}
+
+	for i := range e.SubMessages {
+		parts = append(parts, &ParsedPart{Kind: PartSubMessage, ContentType: e.SubMessages[i].ContentType, Message: &e.SubMessages[i]})
+	}
+
+	for _, part := range parts {
+		err := fn(part)
+		if err == SkipPart {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if part.Kind == PartSubMessage {
+			err = part.Message.walk(fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WalkStructure visits the MIME parts of the email in Structure, see Part.Walk. It is not called Walk as Email.Walk
+// already visits the flattened fields.
+func (e *Email) WalkStructure(fn func(part *Part) error) error {
+	if e.Structure == nil {
+		return nil
+	}
+
+	return e.Structure.Walk(fn)
+}
+
+// Walk visits the part and all its descendants depth-first in the order they appear in the message. Multipart
+// container parts are visited before their children. If fn returns SkipPart for a part its children are
+// skipped, SkipAll stops the walk and any other error stops the walk and is returned.
+func (p *Part) Walk(fn func(part *Part) error) error {
+	err := p.walk(fn)
+	if err == SkipAll {
+		return nil
+	}
+
+	return err
+}
+
+func (p *Part) walk(fn func(part *Part) error) error {
+	err := fn(p)
+	if err == SkipPart {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, child := range p.Children {
+		err = child.walk(fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TextBodyInCharset returns the text body encoded in the given charset, e.g. TextBodyCharset to re-emit
+// the body the way it was received. Options.CharsetEncoder is used when set, otherwise the charsets known to
+// golang.org/x/text/encoding/htmlindex are supported.
+func (e *Email) TextBodyInCharset(charset string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return []byte(e.TextBody), nil
+	}
+
+	if e.charsetEncoder == nil {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported charset: %s", charset)
+		}
+
+		return enc.NewEncoder().Bytes([]byte(e.TextBody))
+	}
+
+	encoded, err := e.charsetEncoder(charset, strings.NewReader(e.TextBody))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(encoded)
+}
+
+// Preview returns a short plain text snippet of the email for list views, at most maxChars characters long.
+// The text body is preferred over the html body stripped of its markup. Quoted replies with their attribution
+// line ("On ... wrote:") and the signature are left out and whitespace is collapsed. Longer text is cut at
+// a word boundary and ends with an ellipsis.
+func (e *Email) Preview(maxChars int) string {
+	text := e.TextBody
+	if strings.TrimSpace(text) == "" {
+		text = htmlToText(e.HTMLBody, true)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "-- " {
+			break
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ">") || replyAttributionRegexp.MatchString(line) {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return truncateWords(strings.Join(strings.Fields(strings.Join(lines, " ")), " "), maxChars)
+}
+
+// Text returns the text body, or the html body converted to readable plain text when the email has no text body.
+// The conversion drops scripts, styles and the head, keeps the line breaks of <br>, paragraphs and other block
+// elements and the whitespace of <pre>, starts list items with "- " or their number and decodes entities.
+func (e *Email) Text() string {
+	if strings.TrimSpace(e.TextBody) != "" || e.HTMLBody == "" {
+		return e.TextBody
+	}
+
+	return htmlToText(e.HTMLBody, false)
+}
+
+// htmlToText renders html as plain text, see Email.Text. With dropQuotes, <blockquote> elements, which hold
+// quoted replies, are left out as well.
+func htmlToText(s string, dropQuotes bool) string {
+	var w textWriter
+	var lists []int // the next number of each enclosing ordered list, or -1 for unordered lists
+	hidden, quoted, pre := 0, 0, 0
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return strings.TrimRight(w.b.String(), " \t\r\n")
+		}
+
+		tag := z.Token()
+		switch tt {
+		case html.TextToken:
+			if hidden == 0 && quoted == 0 {
+				w.text(tag.Data, pre > 0)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch tag.DataAtom {
+			case atom.Head, atom.Script, atom.Style, atom.Template:
+				if tt == html.StartTagToken {
+					hidden++
+				}
+			case atom.Body:
+				// browsers close an unclosed head at the body
+				hidden = 0
+			case atom.Br:
+				w.lineBreak()
+			case atom.Blockquote:
+				w.block()
+				if dropQuotes && tt == html.StartTagToken {
+					quoted++
+				}
+			case atom.Pre:
+				w.block()
+				pre++
+				w.dropNewline = true
+			case atom.Ul, atom.Ol:
+				w.block()
+				number := -1
+				if tag.DataAtom == atom.Ol {
+					number = 1
+				}
+				lists = append(lists, number)
+			case atom.Li:
+				w.block()
+				marker := "-"
+				if len(lists) > 0 && lists[len(lists)-1] > 0 {
+					marker = strconv.Itoa(lists[len(lists)-1]) + "."
+					lists[len(lists)-1]++
+				}
+				w.text(marker+" ", false)
+			case atom.Td, atom.Th:
+				w.text(" ", false)
+			default:
+				if isHTMLBlock(tag.DataAtom) {
+					w.block()
+				}
+			}
+		case html.EndTagToken:
+			switch tag.DataAtom {
+			case atom.Head, atom.Script, atom.Style, atom.Template:
+				if hidden > 0 {
+					hidden--
+				}
+			case atom.Blockquote:
+				if quoted > 0 {
+					quoted--
+				}
+				w.block()
+			case atom.Pre:
+				if pre > 0 {
+					pre--
+				}
+				w.block()
+			case atom.Ul, atom.Ol:
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				w.block()
+			default:
+				if isHTMLBlock(tag.DataAtom) {
+					w.block()
+				}
+			}
+		}
+	}
+}
+
+// isHTMLBlock reports whether the html element starts and ends a line of text
+func isHTMLBlock(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Li, atom.Tr, atom.Table, atom.Blockquote, atom.Hr, atom.H1, atom.H2, atom.H3,
+		atom.H4, atom.H5, atom.H6, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Nav, atom.Aside,
+		atom.Main, atom.Dl, atom.Dt, atom.Dd, atom.Address, atom.Figure, atom.Figcaption, atom.Form, atom.Fieldset:
+		return true
+	}
+
+	return false
+}
+
+// textWriter collapses the whitespace of rendered html text and the line breaks between blocks
+type textWriter struct {
+	b strings.Builder
+
+	// space is whitespace pending before the next word, breaks the pending line breaks
+	space  bool
+	breaks int
+
+	// lineStart tells whether nothing was written on the current line yet
+	lineStart bool
+
+	// dropNewline drops a newline right after a <pre> start tag, as browsers do
+	dropNewline bool
+}
 
-	if hp.err != nil {
-		err = hp.err
+func (w *textWriter) text(s string, pre bool) {
+	if pre {
+		if w.dropNewline {
+			s = strings.TrimPrefix(s, "\n")
+		}
+		w.dropNewline = false
+		if s == "" {
+			return
+		}
+
+		w.flush()
+		w.b.WriteString(s)
+		w.lineStart = strings.HasSuffix(s, "\n")
 		return
 	}
 
-	//decode whole header for easier access to extra fields
-	//todo: should we decode? aren't only standard fields mime encoded?
-	email.Header, err = decodeHeaderMime(header)
-	if err != nil {
-		return
+	w.dropNewline = false
+	if strings.TrimLeftFunc(s, unicode.IsSpace) != s {
+		w.space = true
 	}
 
-	return
-}
+	for i, word := range strings.Fields(s) {
+		if i > 0 {
+			w.space = true
+		}
 
-func parseContentType(contentTypeHeader string) (contentType string, params map[string]string, err error) {
-	if contentTypeHeader == "" {
-		contentType = contentTypeTextPlain
-		return
+		w.flush()
+		w.b.WriteString(word)
+		w.lineStart = false
 	}
 
-	return mime.ParseMediaType(contentTypeHeader)
+	if strings.TrimRightFunc(s, unicode.IsSpace) != s {
+		w.space = true
+	}
 }
 
-func parseMultipartRelated(msg io.Reader, boundary string) (textBody, htmlBody string, embeddedFiles []EmbeddedFile, err error) {
-	pmr := multipart.NewReader(msg, boundary)
-	for {
-		part, pmrErr := pmr.NextPart()
+// flush writes the pending line breaks, at most one blank line, or space before the next text
+func (w *textWriter) flush() {
+	if w.b.Len() == 0 {
+		w.breaks, w.space, w.lineStart = 0, false, true
+		return
+	}
 
-		if pmrErr == io.EOF {
-			break
-		} else if pmrErr != nil {
-			err = pmrErr
-			return
+	if w.breaks > 0 {
+		if w.breaks > 2 {
+			w.breaks = 2
 		}
+		w.b.WriteString(strings.Repeat("\n", w.breaks))
+		w.lineStart = true
+	} else if w.space && !w.lineStart {
+		w.b.WriteByte(' ')
+	}
 
-		contentType, params, mimeErr := mime.ParseMediaType(part.Header.Get("Content-Type"))
-		if mimeErr != nil {
-			err = pmrErr
-			return
-		}
+	w.breaks, w.space = 0, false
+}
 
-		switch contentType {
-		case contentTypeTextPlain:
-			ppContent, ioErr := io.ReadAll(part)
-			if ioErr != nil {
-				err = ioErr
-				return
-			}
+// block ends the current line unless it is empty
+func (w *textWriter) block() {
+	if !w.lineStart && w.breaks == 0 {
+		w.breaks = 1
+	}
+	w.space = false
+}
 
-			textBody += strings.TrimSuffix(string(ppContent[:]), "\n")
-		case contentTypeTextHtml:
-			ppContent, ioErr := io.ReadAll(part)
-			if ioErr != nil {
-				err = ioErr
-				return
-			}
+// lineBreak starts a new line for <br>, successive ones leave blank lines
+func (w *textWriter) lineBreak() {
+	w.breaks++
+	w.space = false
+}
 
-			htmlBody += strings.TrimSuffix(string(ppContent[:]), "\n")
-		case contentTypeMultipartAlternative:
-			tb, hb, ef, mpaErr := parseMultipartAlternative(part, params["boundary"])
-			if mpaErr != nil {
-				err = mpaErr
-				return
-			}
+// truncateWords cuts s to at most maxChars characters at a word boundary, ending it with an ellipsis
+func truncateWords(s string, maxChars int) string {
+	runes := []rune(s)
+	if len(runes) <= maxChars {
+		return s
+	}
 
-			htmlBody += hb
-			textBody += tb
-			embeddedFiles = append(embeddedFiles, ef...)
-		default:
-			if isEmbeddedFile(part) {
-				ef, efErr := decodeEmbeddedFile(part)
-				if efErr != nil {
-					err = efErr
-					return
-				}
+	if maxChars <= 0 {
+		return ""
+	}
 
-				embeddedFiles = append(embeddedFiles, ef)
-			} else {
-				err = fmt.Errorf("cannot process multipart/related inner mime type: %s", contentType)
-				return
+	// leave room for the ellipsis
+	cut := runes[:maxChars-1]
+	if runes[len(cut)] != ' ' {
+		for i := len(cut) - 1; i > 0; i-- {
+			if cut[i] == ' ' {
+				cut = cut[:i]
+				break
 			}
 		}
 	}
 
-	return
+	return strings.TrimRight(string(cut), " ") + "…"
 }
 
-func parseMultipartAlternative(msg io.Reader, boundary string) (textBody, htmlBody string, embeddedFiles []EmbeddedFile, err error) {
-	pmr := multipart.NewReader(msg, boundary)
-	for {
-		part, pmrErr := pmr.NextPart()
+// ResolveHTMLLinks returns the html body with the relative URLs of href, src, background and action attributes
+// resolved against ContentBase. Tags holding a resolved URL are rewritten with lower-cased names and quoted values,
+// the rest of the body is kept as it is. Without a valid absolute ContentBase the html body is returned unchanged.
+func (e *Email) ResolveHTMLLinks() string {
+	base, err := url.Parse(e.ContentBase)
+	if err != nil || !base.IsAbs() {
+		return e.HTMLBody
+	}
 
-		if pmrErr == io.EOF {
-			break
-		} else if pmrErr != nil {
-			err = pmrErr
-			return
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(e.HTMLBody))
+	for {
+		tt := z.Next()
+		// the tokenizer lower-cases tag and attribute names in place, keep the tag as written
+		raw := string(z.Raw())
+		if tt == html.ErrorToken {
+			b.WriteString(raw)
+			return b.String()
 		}
 
-		contentType, params, mimeErr := mime.ParseMediaType(part.Header.Get("Content-Type"))
-		if mimeErr != nil {
-			err = mimeErr
-			return
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			b.WriteString(raw)
+			continue
 		}
 
-		switch contentType {
-		case contentTypeTextPlain:
-			ppContent, ioErr := io.ReadAll(part)
-			if ioErr != nil {
-				err = ioErr
-				return
-			}
-
-			textBody += strings.TrimSuffix(string(ppContent[:]), "\n")
-		case contentTypeTextHtml:
-			ppContent, ioErr := io.ReadAll(part)
-			if ioErr != nil {
-				err = ioErr
-				return
+		tag := z.Token()
+		resolved := false
+		for i, attr := range tag.Attr {
+			switch attr.Key {
+			case "href", "src", "background", "action":
+			default:
+				continue
 			}
 
-			htmlBody += strings.TrimSuffix(string(ppContent[:]), "\n")
-		case contentTypeMultipartRelated:
-			tb, hb, ef, mprErr := parseMultipartRelated(part, params["boundary"])
-			if mprErr != nil {
-				err = mprErr
-				return
+			ref, err := url.Parse(strings.TrimSpace(attr.Val))
+			if err != nil || ref.IsAbs() || strings.HasPrefix(attr.Val, "#") {
+				continue
 			}
 
-			htmlBody += hb
-			textBody += tb
-			embeddedFiles = append(embeddedFiles, ef...)
-		default:
-			if isEmbeddedFile(part) {
-				ef, efErr := decodeEmbeddedFile(part)
-				if efErr != nil {
-					err = efErr
-					return
-				}
+			tag.Attr[i].Val = base.ResolveReference(ref).String()
+			resolved = true
+		}
 
-				embeddedFiles = append(embeddedFiles, ef)
-			} else {
-				err = fmt.Errorf("cannot process multipart/alternative inner mime type: %s", contentType)
-				return
-			}
+		if resolved {
+			b.WriteString(tag.String())
+		} else {
+			b.WriteString(raw)
 		}
 	}
+}
 
-	return
+// generatedHeaders are written by Email.WriteTo from the fields of the email rather than taken from its Header
+var generatedHeaders = []string{
+	"From", "Sender", "Reply-To", "To", "Cc", "Bcc", "Subject", "Date", "Message-Id", "In-Reply-To", "References",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding",
 }
 
-func parseMultipartMixed(msg io.Reader, boundary string) (textBody, htmlBody string, attachments []Attachment, embeddedFiles []EmbeddedFile, err error) {
-	pmr := multipart.NewReader(msg, boundary)
-	for {
-		part, pmrErr := pmr.NextPart()
-		if pmrErr == io.EOF {
-			break
-		} else if pmrErr != nil {
-			err = pmrErr
-			return
+// WriteTo serializes the email as a MIME message to w, implementing io.WriterTo. The structure of the message is
+// rebuilt from the parsed email: the text and html bodies become a multipart/alternative, embedded files are related
+// to the html body and attachments and vCards are mixed in, base64 encoded. The originator, destination, subject,
+// date and identification headers are encoded from their fields, other headers are written from Header with
+// non-ASCII values encoded as UTF-8 encoded-words.
+func (e *Email) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	body := e.mimeBody()
+
+	var header bytes.Buffer
+	writeHeaderField(&header, "From", formatAddressList(e.From))
+	if e.Sender != nil {
+		writeHeaderField(&header, "Sender", e.Sender.String())
+	}
+	writeHeaderField(&header, "Reply-To", formatAddressList(e.ReplyTo))
+	writeHeaderField(&header, "To", formatAddressList(e.To))
+	writeHeaderField(&header, "Cc", formatAddressList(e.Cc))
+	writeHeaderField(&header, "Bcc", formatAddressList(e.Bcc))
+	writeHeaderField(&header, "Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	if !e.Date.IsZero() {
+		writeHeaderField(&header, "Date", e.Date.Format(time.RFC1123Z))
+	}
+	if e.MessageID != "" {
+		writeHeaderField(&header, "Message-ID", "<"+e.MessageID+">")
+	}
+	writeHeaderField(&header, "In-Reply-To", formatMessageIdList(e.InReplyTo))
+	writeHeaderField(&header, "References", formatMessageIdList(e.References))
+
+	keys := make([]string, 0, len(e.Header))
+	for k := range e.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if containsFold(generatedHeaders, k) {
+			continue
 		}
 
-		contentType, params, mimeErr := mime.ParseMediaType(part.Header.Get("Content-Type"))
-		if mimeErr != nil {
-			err = mimeErr
-			return
+		for _, v := range e.Header[k] {
+			// header values are held decoded, non-ASCII ones go back out as encoded-words
+			writeHeaderField(&header, k, mime.QEncoding.Encode("utf-8", v))
 		}
+	}
 
-		switch contentType {
-		case contentTypeMultipartAlternative:
-			textBody, htmlBody, embeddedFiles, err = parseMultipartAlternative(part, params["boundary"])
-			if err != nil {
-				return
-			}
+	writeHeaderField(&header, "MIME-Version", "1.0")
+	for _, k := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		writeHeaderField(&header, k, body.header.Get(k))
+	}
+	header.WriteString("\r\n")
 
-		case contentTypeMultipartRelated:
-			textBody, htmlBody, embeddedFiles, err = parseMultipartRelated(part, params["boundary"])
-			if err != nil {
-				return
-			}
+	if _, err := header.WriteTo(cw); err != nil {
+		return cw.n, err
+	}
 
-		default:
-			if isAttachment(part) {
-				at, aErr := decodeAttachment(part)
-				if aErr != nil {
-					err = aErr
-					return
-				}
+	err := body.writeBody(cw)
 
-				attachments = append(attachments, at)
-			} else {
-				ppContent, ioErr := io.ReadAll(part)
-				if ioErr != nil {
-					err = ioErr
-					return
-				}
+	return cw.n, err
+}
 
-				switch contentType {
-				case contentTypeTextPlain:
-					textBody += strings.TrimSuffix(string(ppContent[:]), "\n")
-				case contentTypeTextHtml:
-					htmlBody += strings.TrimSuffix(string(ppContent[:]), "\n")
-				}
-			}
-		}
+// Bytes returns the email serialized as a MIME message, see WriteTo
+func (e *Email) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := e.WriteTo(&buf); err != nil {
+		return nil, err
 	}
 
-	return
+	return buf.Bytes(), nil
 }
 
-func decodeMimeSentence(s string) string {
-	result := []string{}
-	ss := strings.Split(s, " ")
+// mimeBody builds the MIME tree of the body of the email
+func (e *Email) mimeBody() *mimeEntity {
+	var alternatives []*mimeEntity
+	if e.TextBody != "" {
+		alternatives = append(alternatives, newTextEntity(contentTypeTextPlain, e.TextBody))
+	}
 
-	for _, word := range ss {
-		dec := new(mime.WordDecoder)
-		w, err := dec.Decode(word)
-		if err != nil {
-			if len(result) == 0 {
-				w = word
-			} else {
-				w = " " + word
+	var mixed []*mimeEntity
+	if e.HTMLBody != "" {
+		htmlBody := newTextEntity(contentTypeTextHtml, e.HTMLBody)
+		if len(e.EmbeddedFiles) > 0 {
+			related := []*mimeEntity{htmlBody}
+			for i := range e.EmbeddedFiles {
+				related = append(related, newEmbeddedFileEntity(&e.EmbeddedFiles[i]))
 			}
+
+			htmlBody = newMultipartEntity(contentTypeMultipartRelated, related)
 		}
 
-		result = append(result, w)
+		alternatives = append(alternatives, htmlBody)
+	} else {
+		for i := range e.EmbeddedFiles {
+			mixed = append(mixed, newEmbeddedFileEntity(&e.EmbeddedFiles[i]))
+		}
 	}
 
-	return strings.Join(result, "")
-}
+	var content *mimeEntity
+	switch {
+	case len(alternatives) > 1:
+		content = newMultipartEntity(contentTypeMultipartAlternative, alternatives)
+	case len(alternatives) == 1:
+		content = alternatives[0]
+	case e.Content != nil:
+		contentType := e.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
 
-func decodeHeaderMime(header mail.Header) (mail.Header, error) {
-	parsedHeader := map[string][]string{}
+		content = newBase64Entity(textproto.MIMEHeader{"Content-Type": {contentType}}, cloneReader(&e.Content))
+	default:
+		content = newTextEntity(contentTypeTextPlain, "")
+	}
 
-	for headerName, headerData := range header {
+	for i := range e.Attachments {
+		mixed = append(mixed, newAttachmentEntity(&e.Attachments[i]))
+	}
 
-		parsedHeaderData := []string{}
-		for _, headerValue := range headerData {
-			parsedHeaderData = append(parsedHeaderData, decodeMimeSentence(headerValue))
+	for _, vc := range e.VCards {
+		header := textproto.MIMEHeader{"Content-Type": {contentTypeTextVCard}}
+		if vc.Filename != "" {
+			header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": vc.Filename}))
 		}
 
-		parsedHeader[headerName] = parsedHeaderData
+		mixed = append(mixed, newBase64Entity(header, bytes.NewReader(vc.Raw)))
 	}
 
-	return mail.Header(parsedHeader), nil
+	if len(mixed) == 0 {
+		return content
+	}
+
+	return newMultipartEntity(contentTypeMultipartMixed, append([]*mimeEntity{content}, mixed...))
 }
 
-func isEmbeddedFile(part *multipart.Part) bool {
-	return part.Header.Get("Content-Transfer-Encoding") != ""
+// mimeEntity is a part of a message being serialized, either a leaf written by write or a multipart of parts
+type mimeEntity struct {
+	header   textproto.MIMEHeader
+	write    func(w io.Writer) error
+	boundary string
+	parts    []*mimeEntity
 }
 
-func decodeEmbeddedFile(part *multipart.Part) (ef EmbeddedFile, err error) {
-	cid := decodeMimeSentence(part.Header.Get("Content-Id"))
-	decoded, err := decodeContent(part, part.Header.Get("Content-Transfer-Encoding"))
-	if err != nil {
-		return
+func (me *mimeEntity) writeBody(w io.Writer) error {
+	if me.parts == nil {
+		return me.write(w)
 	}
 
-	_, params, _ := parseContentType(part.Header.Get("Content-Type"))
-	if name, ok := params["name"]; ok {
-		ef.Filename = name
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(me.boundary); err != nil {
+		return err
 	}
 
-	ef.CID = strings.Trim(cid, "<>")
-	ef.Data = decoded
-	ef.ContentType = part.Header.Get("Content-Type")
+	for _, part := range me.parts {
+		pw, err := mw.CreatePart(part.header)
+		if err != nil {
+			return err
+		}
 
-	return
-}
+		if err := part.writeBody(pw); err != nil {
+			return err
+		}
+	}
 
-func isAttachment(part *multipart.Part) bool {
-	return part.FileName() != ""
+	return mw.Close()
 }
 
-func decodeAttachment(part *multipart.Part) (at Attachment, err error) {
-	filename := decodeMimeSentence(part.FileName())
-	decoded, err := decodeContent(part, part.Header.Get("Content-Transfer-Encoding"))
-	if err != nil {
-		return
+func newMultipartEntity(mediaType string, parts []*mimeEntity) *mimeEntity {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	return &mimeEntity{
+		header:   textproto.MIMEHeader{"Content-Type": {mime.FormatMediaType(mediaType, map[string]string{"boundary": boundary})}},
+		boundary: boundary,
+		parts:    parts,
 	}
+}
 
-	at.Filename = filename
-	at.Data = decoded
-	at.ContentType = strings.Split(part.Header.Get("Content-Type"), ";")[0]
+// newTextEntity returns a UTF-8 text part encoded as quoted-printable
+func newTextEntity(mediaType, text string) *mimeEntity {
+	return &mimeEntity{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(mediaType, map[string]string{"charset": "utf-8"})},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		write: func(w io.Writer) error {
+			qw := quotedprintable.NewWriter(w)
+			if _, err := io.WriteString(qw, text); err != nil {
+				return err
+			}
 
-	return
+			return qw.Close()
+		},
+	}
 }
 
-func decodeContent(content io.Reader, encoding string) (io.Reader, error) {
-	switch encoding {
-	case "base64":
-		decoded := base64.NewDecoder(base64.StdEncoding, content)
-		b, err := io.ReadAll(decoded)
-		if err != nil {
-			return nil, err
-		}
+// newBase64Entity returns a part with the given header writing data base64 encoded
+func newBase64Entity(header textproto.MIMEHeader, data io.Reader) *mimeEntity {
+	header.Set("Content-Transfer-Encoding", "base64")
 
-		return bytes.NewReader(b), nil
-	case "7bit":
-		dd, err := io.ReadAll(content)
-		if err != nil {
-			return nil, err
-		}
+	return &mimeEntity{
+		header: header,
+		write: func(w io.Writer) error {
+			encoder := base64.NewEncoder(base64.StdEncoding, &base64LineWriter{w: w})
+			if data != nil {
+				if _, err := io.Copy(encoder, data); err != nil {
+					return err
+				}
+			}
 
-		return bytes.NewReader(dd), nil
-	case "":
-		return content, nil
-	default:
-		return nil, fmt.Errorf("unknown encoding: %s", encoding)
+			return encoder.Close()
+		},
 	}
 }
 
-type headerParser struct {
-	header *mail.Header
-	err    error
-}
+func newAttachmentEntity(at *Attachment) *mimeEntity {
+	contentType := at.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
 
-func (hp *headerParser) parseAddress(s string) (ma *mail.Address) {
-	if hp.err != nil {
-		return nil
+	disposition := at.Disposition
+	if disposition == "" {
+		disposition = "attachment"
 	}
 
-	if strings.Trim(s, " \n") != "" {
-		ma, hp.err = mail.ParseAddress(s)
-		return ma
+	dispositionParams := cloneParams(at.DispositionParams)
+	if at.Filename != "" {
+		if dispositionParams == nil {
+			dispositionParams = map[string]string{}
+		}
+
+		dispositionParams["filename"] = at.Filename
 	}
 
-	return nil
-}
+	contentTypeParams := cloneParams(at.ContentTypeParams)
+	if at.Filename != "" {
+		if contentTypeParams == nil {
+			contentTypeParams = map[string]string{}
+		}
 
-func (hp *headerParser) parseAddressList(s string) (ma []*mail.Address) {
-	if hp.err != nil {
-		return
+		contentTypeParams["name"] = at.Filename
 	}
 
-	if strings.Trim(s, " \n") != "" {
-		ma, hp.err = mail.ParseAddressList(s)
-		return
+	header := textproto.MIMEHeader{}
+	if formatted := mime.FormatMediaType(contentType, contentTypeParams); formatted != "" {
+		header.Set("Content-Type", formatted)
+	} else {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, dispositionParams))
+	if at.CID != "" {
+		header.Set("Content-Id", "<"+at.CID+">")
 	}
 
-	return
+	return newBase64Entity(header, cloneReader(&at.Data))
 }
 
-func (hp *headerParser) parseTime(s string) (t time.Time) {
-	if hp.err != nil || s == "" {
-		return
+func newEmbeddedFileEntity(ef *EmbeddedFile) *mimeEntity {
+	contentType := ef.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
 	}
 
-	formats := []string{
-		time.RFC1123Z,
-		"Mon, 2 Jan 2006 15:04:05 -0700",
-		time.RFC1123Z + " (MST)",
-		"Mon, 2 Jan 2006 15:04:05 -0700 (MST)",
+	header := textproto.MIMEHeader{"Content-Type": {contentType}}
+	if ef.CID != "" {
+		header.Set("Content-Id", "<"+ef.CID+">")
 	}
-
-	for _, format := range formats {
-		t, hp.err = time.Parse(format, s)
-		if hp.err == nil {
-			return
-		}
+	if ef.Disposition != "" {
+		header.Set("Content-Disposition", mime.FormatMediaType(ef.Disposition, ef.DispositionParams))
 	}
 
-	return
+	return newBase64Entity(header, cloneReader(&ef.Data))
 }
 
-func (hp *headerParser) parseMessageId(s string) string {
-	if hp.err != nil {
-		return ""
+// writeHeaderField writes a header field unless its value is empty
+func writeHeaderField(buf *bytes.Buffer, name, value string) {
+	if value == "" {
+		return
 	}
 
-	return strings.Trim(s, "<> ")
+	buf.WriteString(name)
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteString("\r\n")
 }
 
-func (hp *headerParser) parseMessageIdList(s string) (result []string) {
-	if hp.err != nil {
-		return
+func formatAddressList(list []*mail.Address) string {
+	formatted := make([]string, len(list))
+	for i, a := range list {
+		formatted[i] = a.String()
 	}
 
-	for _, p := range strings.Split(s, " ") {
-		if strings.Trim(p, " \n") != "" {
-			result = append(result, hp.parseMessageId(p))
+	return strings.Join(formatted, ", ")
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
 		}
 	}
 
-	return
+	return false
 }
 
-// Attachment with filename, content type and data (as a io.Reader)
-type Attachment struct {
-	Filename    string
-	ContentType string
-	Data        io.Reader
+// base64LineWriter breaks base64 encoded output into lines of 76 characters as required by RFC2045
+type base64LineWriter struct {
+	w io.Writer
+	n int
 }
 
-// EmbeddedFile with content id, content type and data (as a io.Reader)
-type EmbeddedFile struct {
-	CID         string
-	Filename    string
-	ContentType string
-	Data        io.Reader
-}
+func (lw *base64LineWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := 76 - lw.n
+		if chunk > len(b) {
+			chunk = len(b)
+		}
 
-// Email with fields for all the headers defined in RFC5322 with it's attachments and
-type Email struct {
-	Header mail.Header
+		n, err := lw.w.Write(b[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
 
-	Subject    string
-	Sender     *mail.Address
-	From       []*mail.Address
-	ReplyTo    []*mail.Address
-	To         []*mail.Address
-	Cc         []*mail.Address
-	Bcc        []*mail.Address
-	Date       time.Time
-	MessageID  string
-	InReplyTo  []string
-	References []string
+		lw.n += chunk
+		b = b[chunk:]
+		if lw.n == 76 {
+			if _, err := io.WriteString(lw.w, "\r\n"); err != nil {
+				return written, err
+			}
 
-	ResentFrom      []*mail.Address
-	ResentSender    *mail.Address
-	ResentTo        []*mail.Address
-	ResentDate      time.Time
-	ResentCc        []*mail.Address
-	ResentBcc       []*mail.Address
-	ResentMessageID string
+			lw.n = 0
+		}
+	}
 
-	ContentType string
-	Content     io.Reader
+	return written, nil
+}
 
-	HTMLBody string
-	TextBody string
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
 
-	Attachments   []Attachment
-	EmbeddedFiles []EmbeddedFile
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+
+	return n, err
 }
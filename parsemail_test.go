@@ -1,10 +1,19 @@
 package parsemail
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/mail"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -585,337 +594,5326 @@ So, "Hello".`,
 	}
 }
 
-func parseDate(in string) time.Time {
-	out, err := time.Parse(time.RFC1123Z, in)
+func TestParseSMIME(t *testing.T) {
+	var testData = map[int]struct {
+		mailData    string
+		isEncrypted bool
+		smimeType   string
+		data        string
+	}{
+		1: {
+			mailData:    smimeEnvelopedExample,
+			isEncrypted: true,
+			smimeType:   "enveloped-data",
+			data:        "encrypted payload",
+		},
+		2: {
+			mailData:    smimeSignedDataExample,
+			isEncrypted: false,
+			smimeType:   "signed-data",
+			data:        "signed payload",
+		},
+	}
+
+	for index, td := range testData {
+		e, err := Parse(strings.NewReader(td.mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if td.isEncrypted != e.IsEncrypted {
+			t.Errorf("[Test Case %v] Wrong encrypted flag. Expected: %v, Got: %v", index, td.isEncrypted, e.IsEncrypted)
+		}
+
+		if td.smimeType != e.SMIMEType {
+			t.Errorf("[Test Case %v] Wrong smime type. Expected: %s, Got: %s", index, td.smimeType, e.SMIMEType)
+		}
+
+		if td.isEncrypted != (e.Encrypted != nil) {
+			t.Errorf("[Test Case %v] Wrong encrypted content. Got: %v", index, e.Encrypted)
+		} else if e.Encrypted != nil && (e.Encrypted.Protocol != "application/pkcs7-mime" || string(e.Encrypted.Data) != td.data) {
+			t.Errorf("[Test Case %v] Wrong encrypted content. Got: %s %q", index, e.Encrypted.Protocol, string(e.Encrypted.Data))
+		}
+
+		if e.SMIMEData == nil {
+			t.Errorf("[Test Case %v] Missing smime data", index)
+			continue
+		}
+
+		b, err := io.ReadAll(e.SMIMEData)
+		if err != nil {
+			t.Error(err)
+		} else if td.data != string(b) {
+			t.Errorf("[Test Case %v] Wrong smime data. Expected: %s, Got: %s", index, td.data, string(b))
+		}
+	}
+}
+
+func TestFindAttachment(t *testing.T) {
+	var testData = map[int]struct {
+		match    func(PartMeta) bool
+		found    bool
+		filename string
+		data     string
+	}{
+		1: {
+			match:    func(pm PartMeta) bool { return pm.ContentType == "application/pdf" },
+			found:    true,
+			filename: "invoice.pdf",
+			data:     "%PDF-1.4",
+		},
+		2: {
+			match:    func(pm PartMeta) bool { return strings.HasSuffix(pm.Filename, ".csv") },
+			found:    true,
+			filename: "data.csv",
+			data:     "a,b,c",
+		},
+		3: {
+			match: func(pm PartMeta) bool { return pm.ContentType == "image/png" },
+			found: false,
+		},
+	}
+
+	for index, td := range testData {
+		at, err := FindAttachment(strings.NewReader(multipleAttachmentsExample), td.match)
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if !td.found {
+			if at != nil {
+				t.Errorf("[Test Case %v] Unexpected attachment found: %s", index, at.Filename)
+			}
+			continue
+		}
+
+		if at == nil {
+			t.Errorf("[Test Case %v] Attachment not found", index)
+			continue
+		}
+
+		if td.filename != at.Filename {
+			t.Errorf("[Test Case %v] Wrong filename. Expected: %s, Got: %s", index, td.filename, at.Filename)
+		}
+
+		b, err := io.ReadAll(at.Data)
+		if err != nil {
+			t.Error(err)
+		} else if td.data != string(b) {
+			t.Errorf("[Test Case %v] Wrong data. Expected: %s, Got: %s", index, td.data, string(b))
+		}
+	}
+}
+
+func TestParseWithCharsetReader(t *testing.T) {
+	gb2312 := map[string]string{
+		"\xd6\xd0\xce\xc4": "中文",
+	}
+
+	opts := Options{
+		CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+			if strings.ToLower(charset) != "gb2312" {
+				return nil, fmt.Errorf("unsupported charset: %s", charset)
+			}
+
+			b, err := io.ReadAll(input)
+			if err != nil {
+				return nil, err
+			}
+
+			return strings.NewReader(gb2312[string(b)]), nil
+		},
+	}
+
+	e, err := ParseWithOptions(strings.NewReader(gb2312SubjectExample), opts)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
 
-	return out
+	if e.Subject != "中文" {
+		t.Errorf("Wrong subject. Expected: %s, Got: %s", "中文", e.Subject)
+	}
+
+	if len(e.From) != 1 || e.From[0].Name != "中文" {
+		t.Errorf("Wrong from. Expected: %s, Got: %v", "中文", e.From)
+	}
+
+	e, err = Parse(strings.NewReader(gb2312SubjectExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// GB2312 is among the built-in charsets
+	if e.Subject != "中文" {
+		t.Errorf("Wrong subject without charset reader. Expected: %s, Got: %s", "中文", e.Subject)
+	}
+
+	if len(e.From) != 1 || e.From[0].Name != "中文" {
+		t.Errorf("Wrong from without charset reader. Expected: %s, Got: %v", "中文", e.From)
+	}
 }
 
-type attachmentData struct {
-	filename    string
-	contentType string
-	data        string
+func TestTextBodyInCharset(t *testing.T) {
+	opts := Options{
+		CharsetEncoder: func(charset string, input io.Reader) (io.Reader, error) {
+			if strings.ToLower(charset) != "iso-8859-1" {
+				return nil, fmt.Errorf("unsupported charset: %s", charset)
+			}
+
+			b, err := io.ReadAll(input)
+			if err != nil {
+				return nil, err
+			}
+
+			encoded := []byte{}
+			for _, r := range string(b) {
+				encoded = append(encoded, byte(r))
+			}
+
+			return strings.NewReader(string(encoded)), nil
+		},
+	}
+
+	e, err := ParseWithOptions(strings.NewReader(latin1TextExample), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBodyCharset != "iso-8859-1" {
+		t.Errorf("Wrong text body charset. Expected: %s, Got: %s", "iso-8859-1", e.TextBodyCharset)
+	}
+
+	if e.HTMLBodyCharset != "" {
+		t.Errorf("Wrong html body charset. Expected: %s, Got: %s", "", e.HTMLBodyCharset)
+	}
+
+	b, err := e.TextBodyInCharset(e.TextBodyCharset)
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != "Caf\xe9" {
+		t.Errorf("Wrong encoded body. Expected: %q, Got: %q", "Caf\xe9", string(b))
+	}
+
+	b, err = e.TextBodyInCharset("UTF-8")
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != e.TextBody {
+		t.Errorf("Wrong utf-8 body. Expected: %q, Got: %q", e.TextBody, string(b))
+	}
+
+	e, err = Parse(strings.NewReader(latin1TextExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.TextBodyCharsets) != 1 || e.TextBodyCharsets[0] != "iso-8859-1" {
+		t.Errorf("Wrong text body charsets. Expected: %v, Got: %v", []string{"iso-8859-1"}, e.TextBodyCharsets)
+	}
+
+	b, err = e.TextBodyInCharset("iso-8859-1")
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != "Caf\xe9" {
+		t.Errorf("Wrong encoded body without encoder. Expected: %q, Got: %q", "Caf\xe9", string(b))
+	}
+
+	b, err = e.TextBodyInCharset("US-ASCII")
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != e.TextBody {
+		t.Errorf("Wrong us-ascii body. Expected: %q, Got: %q", e.TextBody, string(b))
+	}
+
+	if _, err := e.TextBodyInCharset("x-unknown"); err == nil {
+		t.Error("Expected an error for an unknown charset")
+	}
 }
 
-type embeddedFileData struct {
-	cid         string
-	contentType string
-	base64data  string
+func TestParseIdentityTransferEncodings(t *testing.T) {
+	var testData = map[int]struct {
+		encoding string
+	}{
+		1: {encoding: "none"},
+		2: {encoding: " "},
+		3: {encoding: ""},
+	}
+
+	for index, td := range testData {
+		mailData := strings.Replace(identityEncodingExample, "{{encoding}}", td.encoding, -1)
+
+		e, err := Parse(strings.NewReader(mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if len(e.Attachments) != 1 {
+			t.Errorf("[Test Case %v] Incorrect number of attachments! Expected: %v, Got: %v.", index, 1, len(e.Attachments))
+			continue
+		}
+
+		b, err := io.ReadAll(e.Attachments[0].Data)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != "a,b,c\n" {
+			t.Errorf("[Test Case %v] Wrong attachment data. Expected: %q, Got: %q", index, "a,b,c\n", string(b))
+		}
+	}
 }
 
-func assertSliceEq(a, b []string) bool {
-	if len(a) == len(b) && len(a) == 0 {
-		return true
+func TestAttachmentAndEmbeddedFileCount(t *testing.T) {
+	var testData = map[int]struct {
+		mailData          string
+		attachmentCount   int
+		embeddedFileCount int
+	}{
+		1: {
+			mailData: rfc5322exampleA11,
+		},
+		2: {
+			mailData:        data1,
+			attachmentCount: 1,
+		},
+		3: {
+			mailData:          data2,
+			embeddedFileCount: 1,
+		},
+		4: {
+			mailData:        multipleAttachmentsExample,
+			attachmentCount: 2,
+		},
 	}
 
-	if a == nil && b == nil {
-		return true
+	for index, td := range testData {
+		e, err := Parse(strings.NewReader(td.mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if td.attachmentCount != e.AttachmentCount() {
+			t.Errorf("[Test Case %v] Wrong attachment count. Expected: %v, Got: %v", index, td.attachmentCount, e.AttachmentCount())
+		}
+
+		if td.embeddedFileCount != e.EmbeddedFileCount() {
+			t.Errorf("[Test Case %v] Wrong embedded file count. Expected: %v, Got: %v", index, td.embeddedFileCount, e.EmbeddedFileCount())
+		}
 	}
+}
 
-	if a == nil || b == nil {
-		return false
+func TestRecipientCount(t *testing.T) {
+	var testData = map[int]struct {
+		mailData string
+		count    int
+	}{
+		1: {
+			mailData: rfc5322exampleA11,
+			count:    1,
+		},
+		2: {
+			mailData: rfc5322exampleA12,
+			count:    5,
+		},
+		3: {
+			mailData: recipientsExample,
+			count:    4,
+		},
 	}
 
-	if len(a) != len(b) {
-		return false
+	for index, td := range testData {
+		e, err := Parse(strings.NewReader(td.mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if td.count != e.RecipientCount() {
+			t.Errorf("[Test Case %v] Wrong recipient count. Expected: %v, Got: %v", index, td.count, e.RecipientCount())
+		}
 	}
+}
 
-	for i := range a {
-		if a[i] != b[i] {
-			return false
+func TestContentLanguages(t *testing.T) {
+	var testData = map[int]struct {
+		header    string
+		languages []string
+	}{
+		1: {header: "", languages: nil},
+		2: {header: "en", languages: []string{"en"}},
+		3: {header: "en-US, de ,fr-CA", languages: []string{"en-US", "de", "fr-CA"}},
+		4: {header: "da, en-gb;q=0.8, en;q=0.7", languages: []string{"da", "en-gb", "en"}},
+		5: {header: "en,, ", languages: []string{"en"}},
+	}
+
+	for index, td := range testData {
+		e := Email{Header: mail.Header{}}
+		if td.header != "" {
+			e.Header["Content-Language"] = []string{td.header}
+		}
+
+		languages := e.ContentLanguages()
+		if !assertSliceEq(td.languages, languages) {
+			t.Errorf("[Test Case %v] Wrong languages. Expected: %v, Got: %v", index, td.languages, languages)
 		}
 	}
+}
 
-	return true
+func TestDeliveryHeaders(t *testing.T) {
+	e, err := Parse(strings.NewReader(deliveredToExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []mail.Address{
+		{Address: "mary@example.net"},
+		{Address: "sales@example.net"},
+	}
+
+	d := dereferenceAddressList(e.DeliveredTo())
+	if !assertAddressListEq(expected, d) {
+		t.Errorf("Wrong delivered to. Expected: %s, Got: %s", expected, d)
+	}
+
+	if e.OriginalTo() == nil || e.OriginalTo().Address != "info@example.net" {
+		t.Errorf("Wrong original to. Expected: %s, Got: %v", "info@example.net", e.OriginalTo())
+	}
+
+	if e.ReturnPath() != "bounces@example.org" {
+		t.Errorf("Wrong return path. Expected: %s, Got: %s", "bounces@example.org", e.ReturnPath())
+	}
+
+	e, err = Parse(strings.NewReader(rfc5322exampleA11))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.DeliveredTo() != nil || e.OriginalTo() != nil || e.ReturnPath() != "" {
+		t.Errorf("Unexpected delivery headers: %v %v %q", e.DeliveredTo(), e.OriginalTo(), e.ReturnPath())
+	}
+
+	e = Email{Header: mail.Header{"Return-Path": []string{"<>"}}}
+	if e.ReturnPath() != "" {
+		t.Errorf("Wrong null return path. Expected: %q, Got: %q", "", e.ReturnPath())
+	}
 }
 
-func assertAddressListEq(a, b []mail.Address) bool {
-	if len(a) == len(b) && len(a) == 0 {
-		return true
+func TestAttachmentsByType(t *testing.T) {
+	e := Email{
+		Attachments: []Attachment{
+			{Filename: "a.png", ContentType: "image/png"},
+			{Filename: "b.pdf", ContentType: "Application/PDF"},
+			{Filename: "c.jpg", ContentType: " IMAGE/jpeg; name=c.jpg"},
+			{Filename: "d.json", ContentType: "application/json"},
+		},
+	}
+
+	var testData = map[int]struct {
+		prefix    string
+		filenames []string
+	}{
+		1: {prefix: "image/", filenames: []string{"a.png", "c.jpg"}},
+		2: {prefix: "application/pdf", filenames: []string{"b.pdf"}},
+		3: {prefix: "APPLICATION/", filenames: []string{"b.pdf", "d.json"}},
+		4: {prefix: "video/", filenames: nil},
+		5: {prefix: "", filenames: []string{"a.png", "b.pdf", "c.jpg", "d.json"}},
+	}
+
+	for index, td := range testData {
+		var filenames []string
+		for _, at := range e.AttachmentsByType(td.prefix) {
+			filenames = append(filenames, at.Filename)
+		}
+
+		if !assertSliceEq(td.filenames, filenames) {
+			t.Errorf("[Test Case %v] Wrong attachments. Expected: %v, Got: %v", index, td.filenames, filenames)
+		}
+	}
+}
+
+func TestParseSensitivity(t *testing.T) {
+	var testData = map[int]struct {
+		header      string
+		sensitivity string
+	}{
+		1: {header: "Sensitivity: Personal\n", sensitivity: SensitivityPersonal},
+		2: {header: "Sensitivity:   private  \n", sensitivity: SensitivityPrivate},
+		3: {header: "Sensitivity: COMPANY-CONFIDENTIAL\n", sensitivity: SensitivityCompanyConfidential},
+		4: {header: "Sensitivity: Company - Confidential\n", sensitivity: SensitivityCompanyConfidential},
+		5: {header: "Sensitivity: Secret\n", sensitivity: "Secret"},
+		6: {header: "", sensitivity: ""},
+	}
+
+	for index, td := range testData {
+		e, err := Parse(strings.NewReader(td.header + rfc5322exampleA11))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if td.sensitivity != e.Sensitivity {
+			t.Errorf("[Test Case %v] Wrong sensitivity. Expected: '%s', Got: '%s'", index, td.sensitivity, e.Sensitivity)
+		}
+	}
+}
+
+func TestBuildReplyHeaders(t *testing.T) {
+	longReferences := []string{}
+	for i := 0; i < 30; i++ {
+		longReferences = append(longReferences, fmt.Sprintf("%d@example.net", i))
 	}
 
-	if a == nil && b == nil {
-		return true
-	}
+	var testData = map[int]struct {
+		original   Email
+		inReplyTo  string
+		references string
+	}{
+		1: {
+			original:   Email{MessageID: "1234@local.machine.example"},
+			inReplyTo:  "<1234@local.machine.example>",
+			references: "<1234@local.machine.example>",
+		},
+		2: {
+			original: Email{
+				MessageID:  "abcd.1234@local.machine.test",
+				InReplyTo:  []string{"3456@example.net"},
+				References: []string{"1234@local.machine.example", "3456@example.net"},
+			},
+			inReplyTo:  "<abcd.1234@local.machine.test>",
+			references: "<1234@local.machine.example> <3456@example.net> <abcd.1234@local.machine.test>",
+		},
+		3: {
+			original: Email{
+				MessageID: "3456@example.net",
+				InReplyTo: []string{"1234@local.machine.example"},
+			},
+			inReplyTo:  "<3456@example.net>",
+			references: "<1234@local.machine.example> <3456@example.net>",
+		},
+		4: {
+			original: Email{},
+		},
+		5: {
+			original: Email{
+				MessageID:  "30@example.net",
+				References: longReferences,
+			},
+			inReplyTo:  "<30@example.net>",
+			references: "<0@example.net> " + formatMessageIdList(append(longReferences[12:], "30@example.net")),
+		},
+	}
+
+	for index, td := range testData {
+		reply := Email{}
+		header := reply.BuildReplyHeaders(td.original)
+
+		if td.inReplyTo != header.Get("In-Reply-To") {
+			t.Errorf("[Test Case %v] Wrong In-Reply-To. Expected: '%s', Got: '%s'", index, td.inReplyTo, header.Get("In-Reply-To"))
+		}
+
+		if td.references != header.Get("References") {
+			t.Errorf("[Test Case %v] Wrong References. Expected: '%s', Got: '%s'", index, td.references, header.Get("References"))
+		}
+
+		if len(reply.References) > maxReplyReferences {
+			t.Errorf("[Test Case %v] Too many references: %v", index, len(reply.References))
+		}
+	}
+}
+
+func TestParseTransferEncoding(t *testing.T) {
+	var testData = map[int]struct {
+		mailData         string
+		transferEncoding string
+	}{
+		1: {mailData: rfc5322exampleA11, transferEncoding: ""},
+		2: {mailData: imageContentExample, transferEncoding: "base64"},
+		3: {mailData: encodedTextExample, transferEncoding: "base64"},
+		4: {mailData: strings.Replace(encodedTextExample, "Content-Transfer-Encoding: base64", "Content-Transfer-Encoding: 8bit ", 1), transferEncoding: "8bit"},
+	}
+
+	for index, td := range testData {
+		e, err := Parse(strings.NewReader(td.mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if td.transferEncoding != e.TransferEncoding {
+			t.Errorf("[Test Case %v] Wrong transfer encoding. Expected: '%s', Got: '%s'", index, td.transferEncoding, e.TransferEncoding)
+		}
+	}
+}
+
+func TestParseExpiresAndReplyBy(t *testing.T) {
+	var testData = map[int]struct {
+		headers string
+		expires time.Time
+		replyBy time.Time
+	}{
+		1: {
+			headers: "Expires: Mon, 24 Nov 1997 14:22:01 -0800\nReply-By: Sat, 22 Nov 1997 09:00:00 -0600\n",
+			expires: parseDate("Mon, 24 Nov 1997 14:22:01 -0800"),
+			replyBy: parseDate("Sat, 22 Nov 1997 09:00:00 -0600"),
+		},
+		2: {
+			headers: "Expires: Mon, 24 Nov 1997 14:22:01 -0800 (PST)\n",
+			expires: parseDate("Mon, 24 Nov 1997 14:22:01 -0800"),
+		},
+		3: {
+			headers: "Expires: next week\nReply-By: soon\n",
+		},
+		4: {
+			headers: "",
+		},
+	}
+
+	for index, td := range testData {
+		e, err := Parse(strings.NewReader(td.headers + rfc5322exampleA11))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if !td.expires.Equal(e.Expires) {
+			t.Errorf("[Test Case %v] Wrong expires. Expected: %v, Got: %v", index, td.expires, e.Expires)
+		}
+
+		if !td.replyBy.Equal(e.ReplyBy) {
+			t.Errorf("[Test Case %v] Wrong reply by. Expected: %v, Got: %v", index, td.replyBy, e.ReplyBy)
+		}
+	}
+}
+
+func TestRootMessageID(t *testing.T) {
+	var testData = map[int]struct {
+		mailData string
+		rootID   string
+	}{
+		1: {
+			mailData: rfc5322exampleA11,
+			rootID:   "1234@local.machine.example",
+		},
+		2: {
+			mailData: rfc5322exampleA2a,
+			rootID:   "1234@local.machine.example",
+		},
+		3: {
+			mailData: rfc5322exampleA2b,
+			rootID:   "1234@local.machine.example",
+		},
+		4: {
+			mailData: inReplyToOnlyExample,
+			rootID:   "3456@example.net",
+		},
+	}
+
+	for index, td := range testData {
+		e, err := Parse(strings.NewReader(td.mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if td.rootID != e.RootMessageID() {
+			t.Errorf("[Test Case %v] Wrong root message id. Expected: %s, Got: %s", index, td.rootID, e.RootMessageID())
+		}
+	}
+}
+
+func TestParseUnwrapNested(t *testing.T) {
+	e, err := ParseWithOptions(strings.NewReader(quarantinedExample), Options{UnwrapNested: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.SubMessages) != 1 {
+		t.Fatalf("Incorrect number of sub messages! Expected: %v, Got: %v.", 1, len(e.SubMessages))
+	}
+
+	sub := e.SubMessages[0]
+	if sub.Subject != "Cheap watches" {
+		t.Errorf("Wrong sub message subject. Expected: %s, Got: %s", "Cheap watches", sub.Subject)
+	}
+
+	if sub.TextBody != "Buy now." {
+		t.Errorf("Wrong sub message text body. Expected: %s, Got: %s", "Buy now.", sub.TextBody)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", 1, len(e.Attachments))
+	}
+
+	b, err := io.ReadAll(e.Attachments[0].Data)
+	if err != nil {
+		t.Error(err)
+	} else if !strings.HasPrefix(string(b), "Received: from mx.example.com") {
+		t.Errorf("Attachment data was not preserved: %q", string(b))
+	}
+
+	e, err = Parse(strings.NewReader(quarantinedExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.SubMessages) != 0 {
+		t.Errorf("Unexpected sub messages without UnwrapNested: %v", len(e.SubMessages))
+	}
+
+	e, err = ParseWithOptions(strings.NewReader(data1), Options{UnwrapNested: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.SubMessages) != 0 {
+		t.Errorf("Unexpected sub messages for a plain attachment: %v", len(e.SubMessages))
+	}
+}
+
+func TestBestAlternative(t *testing.T) {
+	both := Email{TextBody: "text", HTMLBody: "<p>html</p>"}
+	textOnly := Email{TextBody: "text"}
+
+	var testData = map[int]struct {
+		email       Email
+		accept      []string
+		content     string
+		contentType string
+	}{
+		1: {email: both, accept: []string{"text/html", "text/plain"}, content: "<p>html</p>", contentType: "text/html"},
+		2: {email: both, accept: []string{"text/plain", "text/html"}, content: "text", contentType: "text/plain"},
+		3: {email: textOnly, accept: []string{"text/html", "text/plain"}, content: "text", contentType: "text/plain"},
+		4: {email: textOnly, accept: []string{"text/html"}, content: "", contentType: ""},
+		5: {email: both, accept: []string{"text/x-amp-html", "Text/*"}, content: "<p>html</p>", contentType: "text/html"},
+		6: {email: textOnly, accept: []string{"*/*"}, content: "text", contentType: "text/plain"},
+		7: {email: both, accept: nil, content: "", contentType: ""},
+	}
+
+	for index, td := range testData {
+		content, contentType := td.email.BestAlternative(td.accept)
+		if td.content != content || td.contentType != contentType {
+			t.Errorf("[Test Case %v] Wrong alternative. Expected: %s (%s), Got: %s (%s)", index, td.content, td.contentType, content, contentType)
+		}
+	}
+}
+
+func TestHTMLBodyImages(t *testing.T) {
+	e := Email{
+		HTMLBody: `<html><body>
+<img src="cid:logo@example.com" alt="logo">
+<IMG SRC='data:image/png;base64,iVBORw0KGgo='>
+<img width="600" src="https://example.com/banner.png?a=1&amp;b=2">
+<img src="https://tracker.example.com/open.gif" width=1 height="1px" />
+<img alt="no source">
+<img alt="a>b" src="cid:arrow@example.com">
+<!-- <img src="https://example.com/commented.png"> -->
+<script>document.write('<img src="https://example.com/scripted.png">');</script>
+</body></html>`,
+	}
+
+	expected := []ImageRef{
+		{Src: "cid:logo@example.com", Kind: ImageRefCID, CID: "logo@example.com"},
+		{Src: "data:image/png;base64,iVBORw0KGgo=", Kind: ImageRefData},
+		{Src: "https://example.com/banner.png?a=1&b=2", Kind: ImageRefRemote},
+		{Src: "https://tracker.example.com/open.gif", Kind: ImageRefRemote, Tracker: true},
+		{Src: "cid:arrow@example.com", Kind: ImageRefCID, CID: "arrow@example.com"},
+	}
+
+	images := e.HTMLBodyImages()
+	if len(images) != len(expected) {
+		t.Fatalf("Incorrect number of images! Expected: %v, Got: %v.", len(expected), len(images))
+	}
+
+	for i := range expected {
+		if expected[i] != images[i] {
+			t.Errorf("[Image %v] Wrong image. Expected: %+v, Got: %+v", i, expected[i], images[i])
+		}
+	}
+}
+
+func TestParseBoundaryOverride(t *testing.T) {
+	_, err := Parse(strings.NewReader(mangledBoundaryExample))
+	if err == nil {
+		t.Error("Expected an error when parsing with the declared boundary")
+	}
+
+	opts := Options{
+		BoundaryOverride: map[string]string{"declared-boundary": "actual-boundary"},
+	}
+
+	e, err := ParseWithOptions(strings.NewReader(mangledBoundaryExample), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != "Salvaged text." {
+		t.Errorf("Wrong text body. Expected: %s, Got: %s", "Salvaged text.", e.TextBody)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Errorf("Incorrect number of attachments! Expected: %v, Got: %v.", 1, len(e.Attachments))
+	}
+}
+
+func TestParseHTMLMetaCharset(t *testing.T) {
+	opts := Options{
+		CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+			if charset != "windows-1252" {
+				return nil, fmt.Errorf("unsupported charset: %s", charset)
+			}
+
+			b, err := io.ReadAll(input)
+			if err != nil {
+				return nil, err
+			}
+
+			runes := []rune{}
+			for _, c := range b {
+				runes = append(runes, rune(c))
+			}
+
+			return strings.NewReader(string(runes)), nil
+		},
+	}
+
+	var testData = map[int]struct {
+		meta string
+	}{
+		1: {meta: `<meta charset="ISO-8859-1">`},
+		2: {meta: `<meta http-equiv="Content-Type" content="text/html; charset=iso-8859-1">`},
+	}
+
+	for index, td := range testData {
+		mailData := strings.Replace(htmlMetaCharsetExample, "{{meta}}", td.meta, -1)
+
+		e, err := ParseWithOptions(strings.NewReader(mailData), opts)
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if e.HTMLBodyCharset != "windows-1252" {
+			t.Errorf("[Test Case %v] Wrong html body charset. Expected: %s, Got: %s", index, "windows-1252", e.HTMLBodyCharset)
+		}
+
+		if !strings.Contains(e.HTMLBody, "Café") {
+			t.Errorf("[Test Case %v] Html body was not transcoded: %q", index, e.HTMLBody)
+		}
+	}
+}
+
+func TestParseHTMLMetaCharsetOverride(t *testing.T) {
+	mailData := strings.Replace(htmlMetaCharsetExample, "{{meta}}", `<meta charset="iso-8859-1">`, -1)
+	mailData = strings.Replace(mailData, "Content-Type: text/html\n", "Content-Type: text/html; charset=us-ascii\n", 1)
+
+	// a CharsetReader failing on every charset leaves decoding to golang.org/x/text
+	opts := Options{
+		CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+			return nil, fmt.Errorf("unsupported charset: %s", charset)
+		},
+	}
+
+	e, err := ParseWithOptions(strings.NewReader(mailData), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.HTMLBodyCharset != "windows-1252" {
+		t.Errorf("Wrong html body charset. Expected: %s, Got: %s", "windows-1252", e.HTMLBodyCharset)
+	}
+
+	if !strings.Contains(e.HTMLBody, "Café") {
+		t.Errorf("Html body was not transcoded: %q", e.HTMLBody)
+	}
+
+	// a declared charset which decodes the text fine is kept
+	e, err = ParseWithOptions(strings.NewReader(strings.Replace(mailData, "\xe9", "e", 1)), opts)
+	if err != nil {
+		t.Fatal(err)
+	} else if e.HTMLBodyCharset != "us-ascii" {
+		t.Errorf("Wrong html body charset. Expected: %s, Got: %s", "us-ascii", e.HTMLBodyCharset)
+	}
+
+	// without a CharsetReader the declared charset is trusted
+	e, err = Parse(strings.NewReader(mailData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.HTMLBodyCharset != "us-ascii" || !strings.Contains(e.HTMLBody, "Caf\xe9") {
+		t.Errorf("Html body charset was sniffed without a CharsetReader: %s %q", e.HTMLBodyCharset, e.HTMLBody)
+	}
+}
+
+func TestParseContentDisposition(t *testing.T) {
+	e, err := Parse(strings.NewReader(dispositionExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 2 {
+		t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", 2, len(e.Attachments))
+	}
+
+	at := e.Attachments[0]
+	if at.Disposition != "attachment" {
+		t.Errorf("Wrong disposition. Expected: %s, Got: %s", "attachment", at.Disposition)
+	}
+
+	if at.DispositionParams["size"] != "5" {
+		t.Errorf("Wrong size disposition param. Expected: %s, Got: %s", "5", at.DispositionParams["size"])
+	}
+
+	at = e.Attachments[1]
+	if at.Disposition != "form-data" {
+		t.Errorf("Wrong disposition. Expected: %s, Got: %s", "form-data", at.Disposition)
+	}
+
+	if at.DispositionParams["name"] != "upload" {
+		t.Errorf("Wrong name disposition param. Expected: %s, Got: %s", "upload", at.DispositionParams["name"])
+	}
+
+	e, err = Parse(strings.NewReader(data2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.EmbeddedFiles) != 1 {
+		t.Fatalf("Incorrect number of embedded files! Expected: %v, Got: %v.", 1, len(e.EmbeddedFiles))
+	}
+
+	if e.EmbeddedFiles[0].Disposition != "" || e.EmbeddedFiles[0].DispositionParams != nil {
+		t.Errorf("Unexpected disposition on embedded file without Content-Disposition: %s", e.EmbeddedFiles[0].Disposition)
+	}
+}
+
+func TestParseContentDuration(t *testing.T) {
+	var testData = map[int]struct {
+		duration string
+		expected time.Duration
+	}{
+		1: {duration: "Content-Duration: 33", expected: 33 * time.Second},
+		2: {duration: "Content-Duration:  120 ", expected: 2 * time.Minute},
+		3: {duration: "Content-Duration: 1.5", expected: 0},
+		4: {duration: "Content-Duration: -4", expected: 0},
+		5: {duration: "X-Nothing: here", expected: 0},
+	}
+
+	for index, td := range testData {
+		mailData := strings.Replace(voicemailExample, "{{duration}}", td.duration, -1)
+
+		e, err := Parse(strings.NewReader(mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if len(e.Attachments) != 1 {
+			t.Errorf("[Test Case %v] Incorrect number of attachments! Expected: %v, Got: %v.", index, 1, len(e.Attachments))
+			continue
+		}
+
+		if td.expected != e.Attachments[0].Duration {
+			t.Errorf("[Test Case %v] Wrong duration. Expected: %v, Got: %v", index, td.expected, e.Attachments[0].Duration)
+		}
+	}
+}
+
+func TestParseLenientBase64(t *testing.T) {
+	_, err := Parse(strings.NewReader(injectedBase64Example))
+	if err == nil {
+		t.Error("Expected an error when parsing base64 with illegal characters in strict mode")
+	}
+
+	e, err := ParseWithOptions(strings.NewReader(injectedBase64Example), Options{Lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", 1, len(e.Attachments))
+	}
+
+	b, err := io.ReadAll(e.Attachments[0].Data)
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != "[1, 2, 3]" {
+		t.Errorf("Wrong attachment data. Expected: %s, Got: %s", "[1, 2, 3]", string(b))
+	}
+}
+
+func TestParseAppleDouble(t *testing.T) {
+	e, err := Parse(strings.NewReader(appleDoubleExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != "See attached." {
+		t.Errorf("Wrong text body. Expected: %s, Got: %s", "See attached.", e.TextBody)
+	}
+
+	if len(e.EmbeddedFiles) != 0 {
+		t.Errorf("Unexpected embedded files: %v", len(e.EmbeddedFiles))
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", 1, len(e.Attachments))
+	}
+
+	at := e.Attachments[0]
+	if at.Filename != "report.pdf" || at.ContentType != "application/pdf" {
+		t.Errorf("Wrong attachment. Expected: %s (%s), Got: %s (%s)", "report.pdf", "application/pdf", at.Filename, at.ContentType)
+	}
+
+	b, err := io.ReadAll(at.Data)
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != "%PDF-1.4" {
+		t.Errorf("Wrong attachment data. Expected: %s, Got: %s", "%PDF-1.4", string(b))
+	}
+
+	if at.MacType != "PDF " || at.MacCreator != "CARO" {
+		t.Errorf("Wrong mac type/creator. Expected: %q/%q, Got: %q/%q", "PDF ", "CARO", at.MacType, at.MacCreator)
+	}
+
+	if at.ResourceFork == nil {
+		t.Fatal("Missing resource fork")
+	}
+
+	b, err = io.ReadAll(at.ResourceFork)
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != "resource fork" {
+		t.Errorf("Wrong resource fork. Expected: %s, Got: %s", "resource fork", string(b))
+	}
+}
+
+func TestWalk(t *testing.T) {
+	e := Email{
+		TextBody: "text",
+		HTMLBody: "<p>html</p>",
+		Attachments: []Attachment{
+			{Filename: "huge.iso", ContentType: "application/octet-stream"},
+			{Filename: "small.txt", ContentType: "text/plain"},
+		},
+		EmbeddedFiles: []EmbeddedFile{
+			{CID: "logo", ContentType: "image/png"},
+		},
+		SubMessages: []Email{
+			{TextBody: "first inner text", ContentType: "text/plain"},
+			{TextBody: "second inner text", ContentType: "text/plain"},
+		},
+	}
+
+	describe := func(p *ParsedPart) string {
+		switch p.Kind {
+		case PartTextBody, PartHTMLBody:
+			return p.Body
+		case PartAttachment:
+			return p.Attachment.Filename
+		case PartEmbeddedFile:
+			return p.EmbeddedFile.CID
+		default:
+			return "message " + p.Message.TextBody
+		}
+	}
+
+	var testData = map[int]struct {
+		fn       func(p *ParsedPart) error
+		visited  []string
+		expected error
+	}{
+		1: {
+			fn:      func(p *ParsedPart) error { return nil },
+			visited: []string{"text", "<p>html</p>", "huge.iso", "small.txt", "logo", "message first inner text", "first inner text", "message second inner text", "second inner text"},
+		},
+		2: {
+			fn: func(p *ParsedPart) error {
+				if p.Kind == PartSubMessage && p.Message.TextBody == "first inner text" {
+					return SkipPart
+				}
+
+				return nil
+			},
+			visited: []string{"text", "<p>html</p>", "huge.iso", "small.txt", "logo", "message first inner text", "message second inner text", "second inner text"},
+		},
+		3: {
+			fn: func(p *ParsedPart) error {
+				if p.Kind == PartEmbeddedFile {
+					return SkipAll
+				}
+
+				return nil
+			},
+			visited: []string{"text", "<p>html</p>", "huge.iso", "small.txt", "logo"},
+		},
+		4: {
+			fn: func(p *ParsedPart) error {
+				if p.Kind == PartAttachment {
+					return io.ErrUnexpectedEOF
+				}
+
+				return nil
+			},
+			visited:  []string{"text", "<p>html</p>", "huge.iso"},
+			expected: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for index, td := range testData {
+		visited := []string{}
+		err := e.Walk(func(p *ParsedPart) error {
+			visited = append(visited, describe(p))
+			return td.fn(p)
+		})
+
+		if err != td.expected {
+			t.Errorf("[Test Case %v] Wrong error. Expected: %v, Got: %v", index, td.expected, err)
+		}
+
+		if !assertSliceEq(td.visited, visited) {
+			t.Errorf("[Test Case %v] Wrong visited parts. Expected: %v, Got: %v", index, td.visited, visited)
+		}
+	}
+}
+
+func TestExtractDataURIs(t *testing.T) {
+	e := Email{
+		HTMLBody: `<html><body>
+<img src="data:image/png;base64,iVBORw0KGgo=">
+<img src="https://example.com/remote.png">
+<img src='data:image/gif;base64,!!!'>
+<a href="data:,Hello%2C%20World%21">link</a>
+<p>metadata:foo,bar</p>
+<a title="data:text/plain,title">not a link</a>
+</body></html>`,
+	}
+
+	files := e.ExtractDataURIs()
+	if len(files) != 2 {
+		t.Fatalf("Incorrect number of files! Expected: %v, Got: %v.", 2, len(files))
+	}
+
+	expected := []embeddedFileData{
+		{cid: "data-uri-1@parsemail", contentType: "image/png", base64data: "iVBORw0KGgo="},
+		{cid: "data-uri-2@parsemail", contentType: "text/plain;charset=US-ASCII", base64data: base64.StdEncoding.EncodeToString([]byte("Hello, World!"))},
+	}
+
+	for i, ef := range files {
+		b, err := io.ReadAll(ef.Data)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if ef.CID != expected[i].cid || ef.ContentType != expected[i].contentType || base64.StdEncoding.EncodeToString(b) != expected[i].base64data {
+			t.Errorf("[File %v] Wrong file. Expected: %v, Got: %s %s %q", i, expected[i], ef.CID, ef.ContentType, string(b))
+		}
+	}
+}
+
+func TestParseMaxHeaderSize(t *testing.T) {
+	hugeReferences := "References:" + strings.Repeat(" <1234@local.machine.example>\n", 10000)
+	hugeHeaderExample := strings.Replace(rfc5322exampleA11, "Subject: Saying Hello\n", "Subject: Saying Hello\n"+hugeReferences, 1)
+
+	var testData = map[int]struct {
+		mailData      string
+		maxHeaderSize int64
+		expected      error
+	}{
+		1: {mailData: rfc5322exampleA11, maxHeaderSize: 1024},
+		2: {mailData: rfc5322exampleA11, maxHeaderSize: 64, expected: ErrHeadersTooLarge},
+		3: {mailData: hugeHeaderExample, maxHeaderSize: 64 * 1024, expected: ErrHeadersTooLarge},
+		4: {mailData: hugeHeaderExample, maxHeaderSize: 0},
+		5: {mailData: data1, maxHeaderSize: 1024},
+	}
+
+	for index, td := range testData {
+		e, err := ParseWithOptions(strings.NewReader(td.mailData), Options{MaxHeaderSize: td.maxHeaderSize})
+		if err != td.expected {
+			t.Errorf("[Test Case %v] Wrong error. Expected: %v, Got: %v", index, td.expected, err)
+			continue
+		}
+
+		if err == nil && e.Date.IsZero() {
+			t.Errorf("[Test Case %v] Message was not parsed", index)
+		}
+	}
+
+	e, err := ParseWithOptions(strings.NewReader(data1), Options{MaxHeaderSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 1 || e.HTMLBody != "<div dir=\"ltr\"><br></div>" {
+		t.Errorf("Body was not preserved when limiting the header size")
+	}
+}
+
+func TestParseContentIDOnlyParts(t *testing.T) {
+	var testData = map[int]struct {
+		mailData string
+	}{
+		1: {mailData: strings.Replace(contentIDOnlyExample, "{{container}}", "multipart/mixed", -1)},
+		2: {mailData: strings.Replace(contentIDOnlyExample, "{{container}}", "multipart/related", -1)},
+		3: {mailData: strings.Replace(contentIDOnlyExample, "{{container}}", "multipart/alternative", -1)},
+	}
+
+	for index, td := range testData {
+		e, err := Parse(strings.NewReader(td.mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if e.HTMLBody != `<img src="cid:logo@example.com">` {
+			t.Errorf("[Test Case %v] Wrong html body: %s", index, e.HTMLBody)
+		}
+
+		if len(e.EmbeddedFiles) != 1 {
+			t.Errorf("[Test Case %v] Incorrect number of embedded files! Expected: %v, Got: %v.", index, 1, len(e.EmbeddedFiles))
+			continue
+		}
+
+		ef := e.EmbeddedFiles[0]
+		b, err := io.ReadAll(ef.Data)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if ef.CID != "logo@example.com" || string(b) != "GIF89a" {
+			t.Errorf("[Test Case %v] Wrong embedded file. Expected: %s %s, Got: %s %s", index, "logo@example.com", "GIF89a", ef.CID, string(b))
+		}
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	var testData = map[int]struct {
+		mailData string
+		size     int64
+	}{
+		1: {mailData: rfc5322exampleA11, size: int64(len("This is a message just to say hello.\nSo, \"Hello\".\n"))},
+		2: {mailData: imageContentExample, size: 9},
+		3: {mailData: multipleAttachmentsExample, size: int64(len("Please find the invoice attached.") + len("<p>Please find the invoice attached.</p>") + 9 + 6)},
+	}
+
+	for index, td := range testData {
+		size, err := EstimateSize(strings.NewReader(td.mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if td.size != size {
+			t.Errorf("[Test Case %v] Wrong size. Expected: %v, Got: %v", index, td.size, size)
+		}
+	}
+}
+
+func TestParseUTF8BOM(t *testing.T) {
+	e, err := Parse(strings.NewReader("\xef\xbb\xbf" + rfc5322exampleA11))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := dereferenceAddressList(e.From)
+	expected := []mail.Address{{Name: "John Doe", Address: "jdoe@machine.example"}}
+	if !assertAddressListEq(expected, d) {
+		t.Errorf("Wrong from. Expected: %s, Got: %s", expected, d)
+	}
+
+	if e.Subject != "Saying Hello" {
+		t.Errorf("Wrong subject. Expected: %s, Got: %s", "Saying Hello", e.Subject)
+	}
+
+	if _, ok := e.Header["\xef\xbb\xbfFrom"]; ok {
+		t.Error("Header name contains the byte order mark")
+	}
+}
+
+func TestClone(t *testing.T) {
+	e, err := Parse(strings.NewReader(data1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := e.Clone()
+
+	for i, email := range []Email{e, c} {
+		b, err := io.ReadAll(email.Attachments[0].Data)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != "[1, 2, 3]" {
+			t.Errorf("[Email %v] Wrong attachment data. Expected: %s, Got: %s", i, "[1, 2, 3]", string(b))
+		}
+	}
+
+	c.From[0].Name = "Changed"
+	c.Header["Subject"][0] = "Changed"
+	c.Attachments[0].Filename = "changed.json"
+	c.Attachments[0].Header["X-Attachment-Id"][0] = "changed"
+
+	if e.From[0].Name == "Changed" {
+		t.Error("Changing the clone's From changed the original")
+	}
+
+	if e.Header.Get("Subject") == "Changed" {
+		t.Error("Changing the clone's Header changed the original")
+	}
+
+	if e.Attachments[0].Filename == "changed.json" {
+		t.Error("Changing the clone's attachment changed the original")
+	}
+
+	if e.Attachments[0].Header.Get("X-Attachment-Id") != "f_j17i0f0d0" {
+		t.Error("Changing the clone's attachment Header changed the original")
+	}
+}
+
+func TestParseEncodedTextParts(t *testing.T) {
+	var testData = map[int]struct {
+		mailData string
+		textBody string
+		htmlBody string
+	}{
+		1: {
+			mailData: strings.Replace(encodedTextPartsExample, "{{container}}", "multipart/alternative", -1),
+			textBody: "Hello wörld",
+			htmlBody: "<p>Hello wörld</p>",
+		},
+		2: {
+			mailData: strings.Replace(encodedTextPartsExample, "{{container}}", "multipart/related", -1),
+			textBody: "Hello wörld",
+			htmlBody: "<p>Hello wörld</p>",
+		},
+		3: {
+			mailData: strings.Replace(encodedTextPartsExample, "{{container}}", "multipart/mixed", -1),
+			textBody: "Hello wörld",
+			htmlBody: "<p>Hello wörld</p>",
+		},
+		4: {
+			mailData: encodedTextExample,
+			textBody: "Hello wörld",
+		},
+		5: {
+			mailData: strings.Replace(encodedTextExample, "base64\n\nSGVsbG8gd8O2cmxk", "BINARY\n\nHello wörld", 1),
+			textBody: "Hello wörld",
+		},
+	}
+
+	for index, td := range testData {
+		e, err := Parse(strings.NewReader(td.mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if td.textBody != e.TextBody {
+			t.Errorf("[Test Case %v] Wrong text body. Expected: '%s', Got: '%s'", index, td.textBody, e.TextBody)
+		}
+
+		if td.htmlBody != e.HTMLBody {
+			t.Errorf("[Test Case %v] Wrong html body. Expected: '%s', Got: '%s'", index, td.htmlBody, e.HTMLBody)
+		}
+	}
+}
+
+func TestParseLenientBoundaryTrailingSpace(t *testing.T) {
+	_, err := Parse(strings.NewReader(boundaryTrailingSpaceExample))
+	if err == nil {
+		t.Error("Expected an error when parsing a boundary with a trailing space in strict mode")
+	}
+
+	e, err := ParseWithOptions(strings.NewReader(boundaryTrailingSpaceExample), Options{Lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != "Hello." {
+		t.Errorf("Wrong text body. Expected: %s, Got: %s", "Hello.", e.TextBody)
+	}
+
+	if e.HTMLBody != "<p>Hello.</p>" {
+		t.Errorf("Wrong html body. Expected: %s, Got: %s", "<p>Hello.</p>", e.HTMLBody)
+	}
+}
+
+func TestParseMissingClosingBoundary(t *testing.T) {
+	var testData = map[int]struct {
+		mailData    string
+		textBody    string
+		htmlBody    string
+		attachments []attachmentData
+		truncated   bool
+	}{
+		1: {
+			mailData: missingClosingBoundaryExample,
+			textBody: "See attached.",
+			attachments: []attachmentData{
+				{filename: "data.csv", contentType: "text/csv", data: "a,b,c"},
+			},
+			truncated: true,
+		},
+		2: {
+			mailData:  strings.TrimSuffix(strings.Replace(encodedTextPartsExample, "{{container}}", "multipart/alternative", -1), "--outer--\n"),
+			textBody:  "Hello wörld",
+			htmlBody:  "<p>Hello wörld</p>",
+			truncated: true,
+		},
+		3: {
+			mailData: textPlainInMultipart,
+			textBody: "plain text part",
+		},
+		4: {
+			mailData:  strings.Replace(missingClosingBoundaryExample, "Content-Type: text/csv; name=\"data.csv\"\nContent-Disposition: attachment; filename=\"data.csv\"\n", "Content-Type: application/x-foo\n", 1),
+			textBody:  "See attached.",
+			truncated: true,
+		},
+	}
+
+	for index, td := range testData {
+		e, err := Parse(strings.NewReader(td.mailData))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if td.truncated != e.Truncated {
+			t.Errorf("[Test Case %v] Wrong truncated flag. Expected: %v, Got: %v", index, td.truncated, e.Truncated)
+		}
+
+		if td.textBody != e.TextBody {
+			t.Errorf("[Test Case %v] Wrong text body. Expected: '%s', Got: '%s'", index, td.textBody, e.TextBody)
+		}
+
+		if td.htmlBody != e.HTMLBody {
+			t.Errorf("[Test Case %v] Wrong html body. Expected: '%s', Got: '%s'", index, td.htmlBody, e.HTMLBody)
+		}
+
+		if len(td.attachments) != len(e.Attachments) {
+			t.Errorf("[Test Case %v] Incorrect number of attachments! Expected: %v, Got: %v.", index, len(td.attachments), len(e.Attachments))
+			continue
+		}
+
+		for i, ad := range td.attachments {
+			at := e.Attachments[i]
+			b, err := io.ReadAll(at.Data)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if at.Filename != ad.filename || at.ContentType != ad.contentType || string(b) != ad.data {
+				t.Errorf("[Test Case %v] Wrong attachment. Expected: %v, Got: %s %s %s", index, ad, at.Filename, at.ContentType, string(b))
+			}
+		}
+	}
+}
+
+func TestParseVCards(t *testing.T) {
+	e, err := Parse(strings.NewReader(vcardExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 0 {
+		t.Errorf("Unexpected attachments: %v", len(e.Attachments))
+	}
+
+	if len(e.VCards) != 2 {
+		t.Fatalf("Incorrect number of vcards! Expected: %v, Got: %v.", 2, len(e.VCards))
+	}
+
+	vc := e.VCards[0]
+	if vc.Filename != "jane.vcf" {
+		t.Errorf("Wrong filename. Expected: %s, Got: %s", "jane.vcf", vc.Filename)
+	}
+
+	if vc.FullName != "Jane Doe" {
+		t.Errorf("Wrong full name. Expected: %s, Got: %s", "Jane Doe", vc.FullName)
+	}
+
+	expectedEmails := []string{"jane@example.com", "jane.doe@work.example.com"}
+	if !assertSliceEq(expectedEmails, vc.Emails) {
+		t.Errorf("Wrong emails. Expected: %v, Got: %v", expectedEmails, vc.Emails)
+	}
+
+	if !strings.HasPrefix(string(vc.Raw), "BEGIN:VCARD") {
+		t.Errorf("Wrong raw vcard: %q", string(vc.Raw))
+	}
+
+	vc = e.VCards[1]
+	if vc.Filename != "" || vc.FullName != "John Smith" || !assertSliceEq([]string{"john@example.com"}, vc.Emails) {
+		t.Errorf("Wrong second vcard: %s %s %v", vc.Filename, vc.FullName, vc.Emails)
+	}
+}
+
+func TestParseLazyAttachments(t *testing.T) {
+	e, err := ParseWithOptions(strings.NewReader(multipleAttachmentsExample), Options{LazyAttachments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 2 {
+		t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", 2, len(e.Attachments))
+	}
+
+	if _, ok := e.Attachments[0].Data.(*bytes.Reader); ok {
+		t.Error("Attachment was decoded eagerly")
+	}
+
+	// read out of order to make sure every attachment is located on its own
+	expected := []string{"a,b,c", "%PDF-1.4"}
+	for i, at := range []Attachment{e.Attachments[1], e.Attachments[0]} {
+		b, err := io.ReadAll(at.Data)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != expected[i] {
+			t.Errorf("Wrong attachment data. Expected: %s, Got: %s", expected[i], string(b))
+		}
+	}
+
+	e, err = ParseWithOptions(strings.NewReader(appleDoubleExample), Options{LazyAttachments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := io.ReadAll(e.Attachments[0].Data)
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != "%PDF-1.4" {
+		t.Errorf("Wrong attachment data. Expected: %s, Got: %s", "%PDF-1.4", string(b))
+	}
+
+	// readers without random access are decoded eagerly
+	e, err = ParseWithOptions(io.MultiReader(strings.NewReader(multipleAttachmentsExample)), Options{LazyAttachments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := e.Attachments[0].Data.(*bytes.Reader); !ok {
+		t.Errorf("Attachment was not decoded eagerly: %T", e.Attachments[0].Data)
+	}
+}
+
+func TestParseEmptyMultipartBody(t *testing.T) {
+	header := "From: John Doe <jdoe@machine.example>\n" +
+		"Date: Fri, 21 Nov 1997 09:55:06 -0600\n" +
+		"MIME-Version: 1.0\n"
+
+	var testData = []struct {
+		name    string
+		message string
+	}{
+		{
+			name:    "empty body",
+			message: header + "Content-Type: multipart/mixed; boundary=x\n\n",
+		},
+		{
+			name:    "whitespace body",
+			message: header + "Content-Type: multipart/alternative; boundary=x\n\n\r\n \n",
+		},
+		{
+			name:    "closing boundary only",
+			message: header + "Content-Type: multipart/related; boundary=x\n\n--x--\n",
+		},
+		{
+			name: "empty nested body",
+			message: header + "Content-Type: multipart/mixed; boundary=x\n\n" +
+				"--x\nContent-Type: multipart/alternative; boundary=y\n\n" +
+				"--x--\n",
+		},
+	}
+
+	for _, td := range testData {
+		e, err := Parse(strings.NewReader(td.message))
+		if err != nil {
+			t.Errorf("[%s] Unexpected error: %v", td.name, err)
+			continue
+		}
+
+		if e.TextBody != "" || e.HTMLBody != "" {
+			t.Errorf("[%s] Unexpected bodies: %q %q", td.name, e.TextBody, e.HTMLBody)
+		}
+
+		if len(e.Attachments) != 0 || len(e.EmbeddedFiles) != 0 {
+			t.Errorf("[%s] Unexpected parts: %v attachments, %v embedded files", td.name, len(e.Attachments), len(e.EmbeddedFiles))
+		}
+	}
+}
+
+func TestByContentID(t *testing.T) {
+	e, err := Parse(strings.NewReader(contentIDAttachmentExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testData = []struct {
+		cid         string
+		contentType string
+		data        string
+	}{
+		{
+			cid:         "logo@example.com",
+			contentType: "image/gif",
+			data:        "GIF89a",
+		},
+		{
+			cid:         "cid:chart@example.com",
+			contentType: "image/png",
+			data:        "PNG",
+		},
+		{
+			cid:         "<chart@example.com>",
+			contentType: "image/png",
+			data:        "PNG",
+		},
+	}
+
+	for _, td := range testData {
+		r := e.ByContentID(td.cid)
+		if r == nil {
+			t.Errorf("[%s] Resource not found", td.cid)
+			continue
+		}
+
+		if r.ContentType() != td.contentType {
+			t.Errorf("[%s] Wrong content type. Expected: %s, Got: %s", td.cid, td.contentType, r.ContentType())
+		}
+
+		b, err := r.Bytes()
+		if err != nil {
+			t.Errorf("[%s] %v", td.cid, err)
+		} else if string(b) != td.data {
+			t.Errorf("[%s] Wrong data. Expected: %s, Got: %s", td.cid, td.data, string(b))
+		}
+	}
+
+	if r := e.ByContentID("missing@example.com"); r != nil {
+		t.Errorf("Unexpected resource: %v", r)
+	}
+}
+
+func TestParseMIMEVersion(t *testing.T) {
+	var testData = []struct {
+		name     string
+		message  string
+		version  string
+		warnings []error
+	}{
+		{
+			name:     "multipart with version",
+			message:  multipleAttachmentsExample,
+			version:  "1.0",
+			warnings: nil,
+		},
+		{
+			name:     "multipart without version",
+			message:  appleDoubleExample,
+			version:  "",
+			warnings: []error{ErrMissingMIMEVersion},
+		},
+		{
+			name:     "plain text without version",
+			message:  rfc5322exampleA11,
+			version:  "",
+			warnings: nil,
+		},
+	}
+
+	for _, td := range testData {
+		e, err := Parse(strings.NewReader(td.message))
+		if err != nil {
+			t.Fatalf("[%s] %v", td.name, err)
+		}
+
+		if e.MIMEVersion != td.version {
+			t.Errorf("[%s] Wrong MIME version. Expected: %q, Got: %q", td.name, td.version, e.MIMEVersion)
+		}
+
+		if len(e.Warnings) != 0 {
+			t.Errorf("[%s] Unexpected warnings without strict mode: %v", td.name, e.Warnings)
+		}
+
+		e, err = ParseWithOptions(strings.NewReader(td.message), Options{Strict: true})
+		if err != nil {
+			t.Fatalf("[%s] %v", td.name, err)
+		}
+
+		if len(e.Warnings) != len(td.warnings) {
+			t.Errorf("[%s] Wrong warnings. Expected: %v, Got: %v", td.name, td.warnings, e.Warnings)
+			continue
+		}
+
+		for i := range td.warnings {
+			if !errors.Is(e.Warnings[i], td.warnings[i]) {
+				t.Errorf("[%s] Wrong warning. Expected: %v, Got: %v", td.name, td.warnings[i], e.Warnings[i])
+			}
+		}
+	}
+}
+
+func TestExtractAttachment(t *testing.T) {
+	var testData = []struct {
+		name  string
+		found bool
+		data  string
+	}{
+		{
+			name:  "data.csv",
+			found: true,
+			data:  "a,b,c",
+		},
+		{
+			name:  "invoice.pdf",
+			found: true,
+			data:  "%PDF-1.4",
+		},
+		{
+			name:  "INVOICE.PDF",
+			found: true,
+			data:  "%PDF-1.4",
+		},
+		{
+			name:  "missing.txt",
+			found: false,
+			data:  "",
+		},
+	}
+
+	for _, td := range testData {
+		var buf bytes.Buffer
+		found, err := ExtractAttachment(strings.NewReader(multipleAttachmentsExample), td.name, &buf)
+		if err != nil {
+			t.Errorf("[%s] %v", td.name, err)
+			continue
+		}
+
+		if found != td.found {
+			t.Errorf("[%s] Wrong found. Expected: %v, Got: %v", td.name, td.found, found)
+		}
+
+		if buf.String() != td.data {
+			t.Errorf("[%s] Wrong data. Expected: %s, Got: %s", td.name, td.data, buf.String())
+		}
+	}
+}
+
+func TestParseQuotedPrintableBoundaryLine(t *testing.T) {
+	// a properly escaped line matching the boundary is only decoded after the part has been delimited
+	e, err := Parse(strings.NewReader(strings.Replace(qpBoundaryLineExample, "{{line}}", "=2D=2Dqp", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Forwarded message follows--qp\nContent-Type: text/plain\n\nEnd of forwarded message"
+	if e.TextBody != expected {
+		t.Errorf("Wrong text body. Expected: %q, Got: %q", expected, e.TextBody)
+	}
+
+	if len(e.Warnings) != 0 {
+		t.Errorf("Unexpected warnings: %v", e.Warnings)
+	}
+
+	// an unescaped one left by a broken encoder cuts the part short in the middle of a soft broken line
+	e, err = Parse(strings.NewReader(strings.Replace(qpBoundaryLineExample, "{{line}}", "--qp", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Warnings) != 1 || !errors.Is(e.Warnings[0], ErrQuotedPrintableTruncated) {
+		t.Errorf("Wrong warnings. Expected: %v, Got: %v", []error{ErrQuotedPrintableTruncated}, e.Warnings)
+	}
+}
+
+func TestParseSpamStatus(t *testing.T) {
+	var testData = []struct {
+		status   string
+		score    string
+		expected *SpamStatus
+	}{
+		{
+			status: "Yes, score=7.2 required=5.0 tests=BAYES_99,HTML_MESSAGE,\r\n\tURIBL_BLACK autolearn=no version=3.4.2",
+			expected: &SpamStatus{
+				IsSpam:   true,
+				Score:    7.2,
+				Required: 5.0,
+				Tests:    []string{"BAYES_99", "HTML_MESSAGE", "URIBL_BLACK"},
+			},
+		},
+		{
+			status: "No, hits=-1.9 required=5.0 tests=none",
+			expected: &SpamStatus{
+				IsSpam:   false,
+				Score:    -1.9,
+				Required: 5.0,
+			},
+		},
+		{
+			score: "3.1",
+			expected: &SpamStatus{
+				Score: 3.1,
+			},
+		},
+		{
+			expected: nil,
+		},
+	}
+
+	for index, td := range testData {
+		message := "From: John Doe <jdoe@machine.example>\r\n"
+		if td.status != "" {
+			message += "X-Spam-Status: " + td.status + "\r\n"
+		}
+		if td.score != "" {
+			message += "X-Spam-Score: " + td.score + "\r\n"
+		}
+		message += "\r\nHello"
+
+		e, err := Parse(strings.NewReader(message))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if td.expected == nil || e.SpamStatus == nil {
+			if td.expected != e.SpamStatus {
+				t.Errorf("[Test Case %v] Wrong spam status. Expected: %v, Got: %v", index, td.expected, e.SpamStatus)
+			}
+
+			continue
+		}
+
+		ss := e.SpamStatus
+		if ss.IsSpam != td.expected.IsSpam || ss.Score != td.expected.Score || ss.Required != td.expected.Required {
+			t.Errorf("[Test Case %v] Wrong spam status. Expected: %+v, Got: %+v", index, *td.expected, *ss)
+		}
+
+		if !assertSliceEq(td.expected.Tests, ss.Tests) {
+			t.Errorf("[Test Case %v] Wrong tests. Expected: %v, Got: %v", index, td.expected.Tests, ss.Tests)
+		}
+	}
+}
+
+func TestParseRepair(t *testing.T) {
+	// without repairing, parts lacking a Content-Type are text/plain
+	e, err := Parse(strings.NewReader(missingContentTypeExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 0 {
+		t.Errorf("Incorrect number of attachments! Expected: %v, Got: %v.", 0, len(e.Attachments))
+	}
+
+	if !strings.HasPrefix(e.TextBody, "See the attached report.") {
+		t.Errorf("Wrong text body. Expected it to start with: %s, Got: %s", "See the attached report.", e.TextBody)
+	}
+
+	e, err = ParseWithOptions(strings.NewReader(missingContentTypeExample), Options{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != "See the attached report." {
+		t.Errorf("Wrong text body. Expected: %s, Got: %s", "See the attached report.", e.TextBody)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", 1, len(e.Attachments))
+	}
+
+	at := e.Attachments[0]
+	if at.ContentType != "application/pdf" {
+		t.Errorf("Wrong content type. Expected: %s, Got: %s", "application/pdf", at.ContentType)
+	}
+
+	if at.Disposition != "attachment" {
+		t.Errorf("Wrong disposition. Expected: %s, Got: %s", "attachment", at.Disposition)
+	}
+
+	b, err := io.ReadAll(at.Data)
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != "%PDF-1.4" {
+		t.Errorf("Wrong attachment data. Expected: %s, Got: %s", "%PDF-1.4", string(b))
+	}
+
+	// size limits apply to repaired parts as well, also when only one of them is set
+	for _, opts := range []Options{{MaxAttachmentSize: 4}, {MaxBodySize: 10}, {MaxBodySize: 30, MaxAttachmentSize: 8}} {
+		opts.Repair = true
+		_, err = ParseWithOptions(strings.NewReader(missingContentTypeExample), opts)
+		exceeds := opts.MaxAttachmentSize == 4 || opts.MaxBodySize == 10
+		if exceeds != errors.Is(err, ErrSizeLimitExceeded) {
+			t.Errorf("[%d/%d] Wrong error. Got: %v", opts.MaxBodySize, opts.MaxAttachmentSize, err)
+		}
+	}
+}
+
+func TestParseSMTPDotStuffed(t *testing.T) {
+	message := "From: John Doe <jdoe@machine.example>\r\n" +
+		"Subject: Dots\r\n" +
+		"Date: Fri, 21 Nov 1997 09:55:06 -0600\r\n" +
+		"\r\n" +
+		"..hidden\r\n" +
+		"...\r\n" +
+		"end\r\n"
+
+	for _, terminator := range []string{".\r\n", ""} {
+		e, err := ParseWithOptions(strings.NewReader(message+terminator), Options{SMTPDotStuffed: true})
+		if err != nil {
+			t.Errorf("[%q] %v", terminator, err)
+			continue
+		}
+
+		expected := ".hidden\r\n..\r\nend\r"
+		if e.TextBody != expected {
+			t.Errorf("[%q] Wrong text body. Expected: %q, Got: %q", terminator, expected, e.TextBody)
+		}
+	}
+
+	signed := strings.Replace(signedExample, "\n", "\r\n", -1)
+	expected, err := Parse(strings.NewReader(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ParseWithOptions(strings.NewReader(signed+".\r\n"), Options{SMTPDotStuffed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(e.Signature.SignedContent, expected.Signature.SignedContent) {
+		t.Errorf("Wrong signed content. Expected: %q, Got: %q", expected.Signature.SignedContent, e.Signature.SignedContent)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	var testData = []struct {
+		textBody string
+		htmlBody string
+		maxChars int
+		expected string
+	}{
+		{
+			textBody: "Sounds   good,\nsee you\ttomorrow.\n\nOn Fri, 21 Nov 1997, John Doe wrote:\n> Lunch tomorrow?\n> John\n\n-- \nMary Smith\nSales",
+			maxChars: 100,
+			expected: "Sounds good, see you tomorrow.",
+		},
+		{
+			textBody: "Sounds good, see you tomorrow.",
+			maxChars: 15,
+			expected: "Sounds good,…",
+		},
+		{
+			textBody: "Sounds good, see you tomorrow.",
+			maxChars: 13,
+			expected: "Sounds good,…",
+		},
+		{
+			textBody: "Příliš žluťoučký kůň",
+			maxChars: 12,
+			expected: "Příliš…",
+		},
+		{
+			htmlBody: "<html><head><style>p {}</style></head><body><p>Sounds&nbsp;good</p><div>see you</div>" +
+				"<blockquote>Lunch tomorrow?</blockquote></body></html>",
+			maxChars: 100,
+			expected: "Sounds good see you",
+		},
+		{
+			htmlBody: "<p>Hi</p><blockquote>old one<blockquote>older</blockquote>still old</blockquote>" +
+				"<p>Important middle answer</p><blockquote>old two</blockquote>",
+			maxChars: 100,
+			expected: "Hi Important middle answer",
+		},
+	}
+
+	for index, td := range testData {
+		e := Email{TextBody: td.textBody, HTMLBody: td.htmlBody}
+		if preview := e.Preview(td.maxChars); preview != td.expected {
+			t.Errorf("[Test Case %v] Wrong preview. Expected: %q, Got: %q", index, td.expected, preview)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	var testData = []struct {
+		textBody string
+		htmlBody string
+		expected string
+	}{
+		{textBody: "Plain text", htmlBody: "<p>Html</p>", expected: "Plain text"},
+		{textBody: "", htmlBody: "", expected: ""},
+		{
+			htmlBody: "<html><head><title>Newsletter</title><style>p { color: red }</style></head>\n" +
+				"<body>\n  <h1>Hello &amp; welcome</h1>\n  <p>First\n  paragraph<br>second line</p>\n" +
+				"  <ul>\n    <li>One</li>\n    <li class=\"x\">Two &lt;3</li>\n  </ul>\n" +
+				"  <div><div>Nested</div></div>\n</body></html>",
+			expected: "Hello & welcome\nFirst paragraph\nsecond line\n- One\n- Two <3\nNested",
+		},
+		{
+			htmlBody: "<p>Code:</p><pre>\nline1\n    indented\nline3</pre><p>Done</p>",
+			expected: "Code:\nline1\n    indented\nline3\nDone",
+		},
+		{
+			htmlBody: `<p>See <a title="a>b" href="https://example.com">link</a> and<br><br>bye</p>`,
+			expected: "See link and\n\nbye",
+		},
+		{
+			htmlBody: "<head><meta charset=\"utf-8\"><body><ol><li>First</li><li>Second</li></ol><table><tr><td>a</td><td>b</td></tr></table>",
+			expected: "1. First\n2. Second\na b",
+		},
+	}
+
+	for index, td := range testData {
+		e := Email{TextBody: td.textBody, HTMLBody: td.htmlBody}
+		if text := e.Text(); text != td.expected {
+			t.Errorf("[Test Case %v] Wrong text. Expected: %q, Got: %q", index, td.expected, text)
+		}
+	}
+}
+
+func TestParseListHeaders(t *testing.T) {
+	e, err := Parse(strings.NewReader(listExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.ListID != "golang-nuts.googlegroups.com" {
+		t.Errorf("Wrong list id. Expected: %s, Got: %s", "golang-nuts.googlegroups.com", e.ListID)
+	}
+
+	if e.ListDescription != "Go Nuts" {
+		t.Errorf("Wrong list description. Expected: %s, Got: %s", "Go Nuts", e.ListDescription)
+	}
+
+	expected := []string{"mailto:golang-nuts@googlegroups.com", "https://groups.google.com/group/golang-nuts/post"}
+	if !assertSliceEq(expected, e.ListPost) {
+		t.Errorf("Wrong list post. Expected: %v, Got: %v", expected, e.ListPost)
+	}
+
+	expected = []string{"mailto:golang-nuts+help@googlegroups.com?subject=help", "https://support.google.com/a/users/answer/9308991"}
+	if !assertSliceEq(expected, e.ListHelp) {
+		t.Errorf("Wrong list help. Expected: %v, Got: %v", expected, e.ListHelp)
+	}
+
+	expected = []string{"https://groups.google.com/group/golang-nuts"}
+	if !assertSliceEq(expected, e.ListArchive) {
+		t.Errorf("Wrong list archive. Expected: %v, Got: %v", expected, e.ListArchive)
+	}
+
+	expected = []string{"mailto:golang-nuts+subscribe@googlegroups.com"}
+	if !assertSliceEq(expected, e.ListSubscribe) {
+		t.Errorf("Wrong list subscribe. Expected: %v, Got: %v", expected, e.ListSubscribe)
+	}
+
+	expected = []string{"mailto:golang-nuts+unsubscribe@googlegroups.com", "https://groups.google.com/group/golang-nuts/unsubscribe?id=1"}
+	if !assertSliceEq(expected, e.ListUnsubscribe) {
+		t.Errorf("Wrong list unsubscribe. Expected: %v, Got: %v", expected, e.ListUnsubscribe)
+	}
+
+	if e.ListUnsubscribePost != "List-Unsubscribe=One-Click" {
+		t.Errorf("Wrong list unsubscribe post. Expected: %s, Got: %s", "List-Unsubscribe=One-Click", e.ListUnsubscribePost)
+	}
+
+	expected = []string{"mailto:golang-nuts+owners@googlegroups.com"}
+	if !assertSliceEq(expected, e.ListOwner) {
+		t.Errorf("Wrong list owner. Expected: %v, Got: %v", expected, e.ListOwner)
+	}
+
+	e, err = Parse(strings.NewReader(rfc5322exampleA11))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.ListID != "" || e.ListDescription != "" || e.ListPost != nil || e.ListHelp != nil || e.ListArchive != nil {
+		t.Errorf("Unexpected list headers: %s %s %v %v %v", e.ListID, e.ListDescription, e.ListPost, e.ListHelp, e.ListArchive)
+	}
+
+	if e.ListSubscribe != nil || e.ListUnsubscribe != nil || e.ListOwner != nil || e.ListUnsubscribePost != "" {
+		t.Errorf("Unexpected list headers: %v %v %v %s", e.ListSubscribe, e.ListUnsubscribe, e.ListOwner, e.ListUnsubscribePost)
+	}
+}
+
+func TestResolveHTMLLinks(t *testing.T) {
+	message := "From: John Doe <jdoe@machine.example>\n" +
+		"Content-Base: \"http://www.example.com/\n docs/\"\n" +
+		"Content-Type: text/html; charset=UTF-8\n" +
+		"\n" +
+		`<a href="intro.html">Intro</a> <img src='../logo.gif' alt="src=x"> <a href=/faq?a=1&amp;b=2>FAQ</a>` +
+		` <a href="#top">Top</a> <a href="mailto:jdoe@machine.example">Mail</a> <img src="cid:logo@example.com">`
+
+	e, err := Parse(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.ContentBase != "http://www.example.com/docs/" {
+		t.Errorf("Wrong content base. Expected: %s, Got: %s", "http://www.example.com/docs/", e.ContentBase)
+	}
+
+	expected := `<a href="http://www.example.com/docs/intro.html">Intro</a> <img src="http://www.example.com/logo.gif" alt="src=x">` +
+		` <a href="http://www.example.com/faq?a=1&amp;b=2">FAQ</a> <a href="#top">Top</a>` +
+		` <a href="mailto:jdoe@machine.example">Mail</a> <img src="cid:logo@example.com">`
+	if html := e.ResolveHTMLLinks(); html != expected {
+		t.Errorf("Wrong html. Expected: %s, Got: %s", expected, html)
+	}
+
+	e.HTMLBody = `<A HREF="page.html">Page</A> <IMG SRC="x.gif"> <!-- <a href="comment.html"> --> <P CLASS=note>Unchanged</P>`
+	expected = `<a href="http://www.example.com/docs/page.html">Page</A> <img src="http://www.example.com/docs/x.gif">` +
+		` <!-- <a href="comment.html"> --> <P CLASS=note>Unchanged</P>`
+	if html := e.ResolveHTMLLinks(); html != expected {
+		t.Errorf("Wrong uppercase html. Expected: %s, Got: %s", expected, html)
+	}
+
+	e.ContentBase = ""
+	if html := e.ResolveHTMLLinks(); html != e.HTMLBody {
+		t.Errorf("Html changed without a content base: %s", html)
+	}
+}
+
+func TestParseAttachmentInAlternative(t *testing.T) {
+	for _, opts := range []Options{{}, {LazyAttachments: true}} {
+		e, err := ParseWithOptions(strings.NewReader(bareAlternativeExample), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.TextBody != "Please find the invoice attached." {
+			t.Errorf("Wrong text body. Expected: %s, Got: %s", "Please find the invoice attached.", e.TextBody)
+		}
+
+		if e.HTMLBody != "<p>Please find the invoice attached.</p>" {
+			t.Errorf("Wrong html body. Expected: %s, Got: %s", "<p>Please find the invoice attached.</p>", e.HTMLBody)
+		}
+
+		if len(e.EmbeddedFiles) != 0 {
+			t.Errorf("Unexpected embedded files: %v", len(e.EmbeddedFiles))
+		}
+
+		if len(e.Attachments) != 1 {
+			t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", 1, len(e.Attachments))
+		}
+
+		at := e.Attachments[0]
+		if at.Filename != "invoice.pdf" || at.ContentType != "application/pdf" {
+			t.Errorf("Wrong attachment. Expected: %s (%s), Got: %s (%s)", "invoice.pdf", "application/pdf", at.Filename, at.ContentType)
+		}
+
+		b, err := io.ReadAll(at.Data)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != "%PDF-1.4" {
+			t.Errorf("Wrong attachment data. Expected: %s, Got: %s", "%PDF-1.4", string(b))
+		}
+	}
+}
+
+func TestBytes(t *testing.T) {
+	for _, message := range []string{multipleAttachmentsExample, contentIDAttachmentExample, multipartRelatedExample, vcardExample, rfc5322exampleA11} {
+		e, err := Parse(strings.NewReader(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e.Subject = "Příliš žluťoučký kůň"
+
+		b, err := e.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the original data stays readable
+		original := e.Clone()
+
+		r, err := Parse(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("Cannot parse reconstructed message: %v\n%s", err, b)
+		}
+
+		if r.Subject != e.Subject {
+			t.Errorf("Wrong subject. Expected: %s, Got: %s", e.Subject, r.Subject)
+		}
+
+		if r.MessageID != e.MessageID || !r.Date.Equal(e.Date) {
+			t.Errorf("Wrong message id or date. Expected: %s %v, Got: %s %v", e.MessageID, e.Date, r.MessageID, r.Date)
+		}
+
+		if !assertAddressListEq(dereferenceAddressList(e.From), dereferenceAddressList(r.From)) ||
+			!assertAddressListEq(dereferenceAddressList(e.To), dereferenceAddressList(r.To)) ||
+			!assertAddressListEq(dereferenceAddressList(e.Cc), dereferenceAddressList(r.Cc)) {
+			t.Errorf("Wrong addresses. Expected: %v %v %v, Got: %v %v %v", e.From, e.To, e.Cc, r.From, r.To, r.Cc)
+		}
+
+		// text is written in its canonical form with CRLF line breaks
+		r.TextBody = strings.ReplaceAll(r.TextBody, "\r\n", "\n")
+		r.HTMLBody = strings.ReplaceAll(r.HTMLBody, "\r\n", "\n")
+		if r.TextBody != e.TextBody || r.HTMLBody != e.HTMLBody {
+			t.Errorf("Wrong bodies. Expected: %q %q, Got: %q %q", e.TextBody, e.HTMLBody, r.TextBody, r.HTMLBody)
+		}
+
+		if len(r.Attachments) != len(original.Attachments) {
+			t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", len(original.Attachments), len(r.Attachments))
+		}
+
+		for i, at := range original.Attachments {
+			expected, _ := io.ReadAll(at.Data)
+			got, _ := io.ReadAll(r.Attachments[i].Data)
+			if r.Attachments[i].Filename != at.Filename || r.Attachments[i].ContentType != at.ContentType || !bytes.Equal(expected, got) {
+				t.Errorf("Wrong attachment. Expected: %s %s %q, Got: %s %s %q", at.Filename, at.ContentType, expected,
+					r.Attachments[i].Filename, r.Attachments[i].ContentType, got)
+			}
+		}
+
+		if len(r.EmbeddedFiles) != len(original.EmbeddedFiles) {
+			t.Fatalf("Incorrect number of embedded files! Expected: %v, Got: %v.", len(original.EmbeddedFiles), len(r.EmbeddedFiles))
+		}
+
+		for i, ef := range original.EmbeddedFiles {
+			expected, _ := io.ReadAll(ef.Data)
+			got, _ := io.ReadAll(r.EmbeddedFiles[i].Data)
+			if r.EmbeddedFiles[i].CID != ef.CID || !bytes.Equal(expected, got) {
+				t.Errorf("Wrong embedded file. Expected: %s %q, Got: %s %q", ef.CID, expected, r.EmbeddedFiles[i].CID, got)
+			}
+		}
+
+		if len(r.VCards) != len(e.VCards) {
+			t.Errorf("Incorrect number of vcards! Expected: %v, Got: %v.", len(e.VCards), len(r.VCards))
+		}
+	}
+
+	// decoded header values are encoded again rather than written as 8-bit
+	e, err := Parse(strings.NewReader(strings.Replace(rfc5322exampleA11, "Subject:", "X-Foo: =?utf-8?q?caf=C3=A9?=\nSubject:", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := e.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range b {
+		if c > 0x7f {
+			t.Fatalf("Unexpected 8-bit data in the reconstructed message:\n%s", b)
+		}
+	}
+
+	r, err := Parse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.Header.Get("X-Foo"); got != "café" {
+		t.Errorf("Wrong header value. Expected: %s, Got: %s", "café", got)
+	}
+}
+
+func TestParseDateHeader(t *testing.T) {
+	var testData = []struct {
+		date     string
+		expected time.Time
+	}{
+		{
+			date:     "Fri, 21 Nov 1997 09:55:06 -0600",
+			expected: parseDate("Fri, 21 Nov 1997 09:55:06 -0600"),
+		},
+		{
+			date:     "21 Nov 97 09:55:06 GMT",
+			expected: parseDate("Fri, 21 Nov 1997 09:55:06 +0000"),
+		},
+		{
+			date:     "Sat, 22 Nov 1997 01:55:06 +0900",
+			expected: parseDate("Fri, 21 Nov 1997 10:55:06 -0600"),
+		},
+		{
+			date:     "yesterday, around noon",
+			expected: time.Time{},
+		},
+		{
+			date:     "",
+			expected: time.Time{},
+		},
+	}
+
+	for _, td := range testData {
+		message := "From: =?UTF-8?Q?Ji=C5=99=C3=AD?= <jiri@example.com>\n" +
+			"Subject: =?UTF-8?Q?P=C5=99=C3=ADloha?=\n" +
+			"Message-ID: <1234@local.machine.example>\n"
+		if td.date != "" {
+			message += "Date: " + td.date + "\n"
+		}
+		message += "\nHello"
+
+		e, err := Parse(strings.NewReader(message))
+		if err != nil {
+			t.Errorf("[%s] %v", td.date, err)
+			continue
+		}
+
+		if !e.Date.Equal(td.expected) {
+			t.Errorf("[%s] Wrong date. Expected: %v, Got: %v", td.date, td.expected, e.Date)
+		}
+
+		if e.Subject != "Příloha" || len(e.From) != 1 || e.From[0].Name != "Jiří" || e.MessageID != "1234@local.machine.example" {
+			t.Errorf("[%s] Wrong header fields: %s %v %s", td.date, e.Subject, e.From, e.MessageID)
+		}
+
+		e, err = ParseWithOptions(strings.NewReader(message), Options{Strict: true})
+		if err != nil {
+			t.Errorf("[%s] %v", td.date, err)
+			continue
+		}
+
+		malformed := td.date != "" && td.expected.IsZero()
+		if warned := len(e.Warnings) == 1 && errors.Is(e.Warnings[0], ErrMalformedDate); warned != malformed || len(e.Warnings) > 1 {
+			t.Errorf("[%s] Wrong strict warnings: %v", td.date, e.Warnings)
+		}
+	}
+}
+
+func TestParseForwardedWindows1252(t *testing.T) {
+	windows1252 := map[byte]rune{0x80: '€'}
+	opts := Options{
+		UnwrapNested: true,
+		// dot-stuffing applies to the outer message only
+		SMTPDotStuffed: true,
+		CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+			if strings.ToLower(charset) != "windows-1252" {
+				return nil, fmt.Errorf("unsupported charset: %s", charset)
+			}
+
+			b, err := io.ReadAll(input)
+			if err != nil {
+				return nil, err
+			}
+
+			runes := []rune{}
+			for _, c := range b {
+				if r, ok := windows1252[c]; ok {
+					runes = append(runes, r)
+				} else {
+					runes = append(runes, rune(c))
+				}
+			}
+
+			return strings.NewReader(string(runes)), nil
+		},
+	}
+
+	e, err := ParseWithOptions(strings.NewReader(forwardedWindows1252Example), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.SubMessages) != 1 {
+		t.Fatalf("Incorrect number of sub messages! Expected: %v, Got: %v.", 1, len(e.SubMessages))
+	}
+
+	sub := e.SubMessages[0]
+	if sub.Subject != "Menü" {
+		t.Errorf("Wrong subject. Expected: %s, Got: %s", "Menü", sub.Subject)
+	}
+
+	if sub.TextBody != "Café costs €5\r\n...\r" {
+		t.Errorf("Wrong text body. Expected: %q, Got: %q", "Café costs €5\r\n...\r", sub.TextBody)
+	}
+
+	if sub.TextBodyCharset != "windows-1252" {
+		t.Errorf("Wrong text body charset. Expected: %s, Got: %s", "windows-1252", sub.TextBodyCharset)
+	}
+}
+
+func TestParseQuotedPrintable(t *testing.T) {
+	expected := "Hello w\u00f6rld, this line was wrapped with a soft line break = done"
+
+	e, err := Parse(strings.NewReader(quotedPrintableExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != expected {
+		t.Errorf("Wrong text body. Expected: %q, Got: %q", expected, e.TextBody)
+	}
+
+	e, err = Parse(strings.NewReader(quotedPrintablePartsExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != expected {
+		t.Errorf("Wrong text body. Expected: %q, Got: %q", expected, e.TextBody)
+	}
+
+	if e.HTMLBody != "<p>"+expected+"</p>" {
+		t.Errorf("Wrong html body. Expected: %q, Got: %q", "<p>"+expected+"</p>", e.HTMLBody)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", 1, len(e.Attachments))
+	}
+
+	b, err := io.ReadAll(e.Attachments[0].Data)
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != "a=1,b=\u00e9\n" {
+		t.Errorf("Wrong attachment data. Expected: %q, Got: %q", "a=1,b=\u00e9\n", string(b))
+	}
+
+	e, err = Parse(strings.NewReader(strings.Replace(quotedPrintablePartsExample, "quoted-printable\n\na=3D1,b=3D=C3=A9", "8bit\n\na=1,b=\u00e9", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = io.ReadAll(e.Attachments[0].Data)
+	if err != nil {
+		t.Error(err)
+	} else if string(b) != "a=1,b=\u00e9\n" {
+		t.Errorf("Wrong 8bit attachment data. Expected: %q, Got: %q", "a=1,b=\u00e9\n", string(b))
+	}
+}
+
+func TestParseTransferEncodingCase(t *testing.T) {
+	for _, encoding := range []string{"Base64", "BASE64 ", " base64\t"} {
+		e, err := Parse(strings.NewReader(strings.Replace(encodedTextExample, "Content-Transfer-Encoding: base64", "Content-Transfer-Encoding: "+encoding, 1)))
+		if err != nil {
+			t.Errorf("[%q] %v", encoding, err)
+			continue
+		}
+
+		if e.TextBody != "Hello wörld" {
+			t.Errorf("[%q] Wrong text body. Expected: %s, Got: %s", encoding, "Hello wörld", e.TextBody)
+		}
+
+		message := strings.Replace(quotedPrintablePartsExample, "Content-Transfer-Encoding: quoted-printable", "Content-Transfer-Encoding: Quoted-Printable ", -1)
+		message = strings.Replace(message, "--outer\nContent-Type: text/plain", "--outer\nContent-Type: application/octet-stream; name=\"hello.txt\"\n"+
+			"Content-Disposition: attachment; filename=\"hello.txt\"\nContent-Transfer-Encoding: "+encoding+"\n\nSGVsbG8gd8O2cmxk\n--outer\nContent-Type: text/plain", 1)
+
+		e, err = Parse(strings.NewReader(message))
+		if err != nil {
+			t.Errorf("[%q] %v", encoding, err)
+			continue
+		}
+
+		if !strings.HasPrefix(e.TextBody, "Hello wörld") {
+			t.Errorf("[%q] Wrong text body. Expected: %s..., Got: %s", encoding, "Hello wörld", e.TextBody)
+		}
+
+		if len(e.Attachments) != 2 {
+			t.Errorf("[%q] Incorrect number of attachments! Expected: %v, Got: %v.", encoding, 2, len(e.Attachments))
+			continue
+		}
+
+		b, err := io.ReadAll(e.Attachments[0].Data)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != "Hello wörld" {
+			t.Errorf("[%q] Wrong attachment data. Expected: %s, Got: %s", encoding, "Hello wörld", string(b))
+		}
+	}
+}
+
+func TestParseBuiltinCharsets(t *testing.T) {
+	var testData = []struct {
+		charset  string
+		body     string
+		expected string
+	}{
+		{charset: "ISO-8859-1", body: "Caf\xe9", expected: "Café"},
+		{charset: "windows-1252", body: "\x80 5", expected: "€ 5"},
+		{charset: "iso-8859-2", body: "P\xf8\xedli\xb9", expected: "Příliš"},
+		{charset: "koi8-r", body: "\xf0\xd2\xc9\xd7\xc5\xd4", expected: "Привет"},
+		{charset: "utf-8", body: "Café", expected: "Café"},
+		{charset: "x-unknown", body: "Caf\xe9", expected: "Caf\xe9"},
+	}
+
+	for _, td := range testData {
+		for _, container := range []string{"", "multipart/mixed", "multipart/alternative", "multipart/related"} {
+			message := "From: John Doe <jdoe@machine.example>\n"
+			part := "Content-Type: text/plain; charset=" + td.charset + "\n\n" + td.body + "\n"
+			if container == "" {
+				message += part
+			} else {
+				message += "Content-Type: " + container + "; boundary=b\n\n--b\n" + part + "--b--\n"
+			}
+
+			e, err := Parse(strings.NewReader(message))
+			if err != nil {
+				t.Errorf("[%s %s] %v", td.charset, container, err)
+				continue
+			}
+
+			if e.TextBody != td.expected {
+				t.Errorf("[%s %s] Wrong text body. Expected: %q, Got: %q", td.charset, container, td.expected, e.TextBody)
+			}
+		}
+	}
+}
+
+func TestParseEncodedWords(t *testing.T) {
+	var testData = []struct {
+		subject  string
+		filename string
+		expected string
+	}{
+		{
+			subject:  "=?UTF-8?Q?P=C5=99=C3=ADli=C5=A1_?= =?UTF-8?Q?=C5=BElu=C5=A5ou=C4=8Dk=C3=BD?=",
+			expected: "Příliš žluťoučký",
+		},
+		{
+			subject:  "=?UTF-8?Q?Hello_world?= and =?UTF-8?Q?more?=",
+			expected: "Hello world and more",
+		},
+		{
+			subject:  "=?ISO-8859-2?Q?P=F8=EDli=B9?=",
+			expected: "Příliš",
+		},
+		{
+			subject:  "Plain subject",
+			expected: "Plain subject",
+		},
+		{
+			subject:  "=?x-unknown?Q?abc?=",
+			expected: "=?x-unknown?Q?abc?=",
+		},
+	}
+
+	for _, td := range testData {
+		e, err := Parse(strings.NewReader("From: John Doe <jdoe@machine.example>\nSubject: " + td.subject + "\n\nHello"))
+		if err != nil {
+			t.Errorf("[%s] %v", td.subject, err)
+			continue
+		}
+
+		if e.Subject != td.expected {
+			t.Errorf("[%s] Wrong subject. Expected: %q, Got: %q", td.subject, td.expected, e.Subject)
+		}
+	}
+
+	message := strings.Replace(multipleAttachmentsExample, `filename="data.csv"`, `filename="=?ISO-8859-2?B?UPjtbGm5LmNzdg==?="`, 1)
+	e, err := Parse(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Attachments[1].Filename != "Příliš.csv" {
+		t.Errorf("Wrong filename. Expected: %s, Got: %s", "Příliš.csv", e.Attachments[1].Filename)
+	}
+}
+
+func TestParseMixedTextBeforeAlternative(t *testing.T) {
+	e, err := Parse(strings.NewReader(textBeforeAlternativeExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"Forwarding the note below.", "The note itself."} {
+		if !strings.Contains(e.TextBody, expected) {
+			t.Errorf("Text body %q is missing %q", e.TextBody, expected)
+		}
+	}
+
+	if !strings.HasPrefix(e.TextBody, "Forwarding the note below.") {
+		t.Errorf("Wrong order of text parts: %q", e.TextBody)
+	}
+
+	if e.HTMLBody != "<p>The note itself.</p>" {
+		t.Errorf("Wrong html body. Expected: %s, Got: %s", "<p>The note itself.</p>", e.HTMLBody)
+	}
+}
+
+func TestParseAttachmentDisposition(t *testing.T) {
+	e, err := Parse(strings.NewReader(attachmentDispositionExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != "See attached." {
+		t.Errorf("Wrong text body. Expected: %s, Got: %s", "See attached.", e.TextBody)
+	}
+
+	var testData = []struct {
+		filename    string
+		contentType string
+		disposition string
+		data        string
+	}{
+		{filename: "attachment-1.txt", contentType: "text/plain", disposition: "attachment", data: "notes"},
+		{filename: "logo.gif", contentType: "image/gif", disposition: "inline", data: "GIF89a"},
+	}
+
+	if len(e.Attachments) != len(testData) {
+		t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", len(testData), len(e.Attachments))
+	}
+
+	for i, td := range testData {
+		at := e.Attachments[i]
+		if at.Filename != td.filename || at.ContentType != td.contentType || at.Disposition != td.disposition {
+			t.Errorf("[%d] Wrong attachment. Expected: %q %s %q, Got: %q %s %q", i, td.filename, td.contentType, td.disposition,
+				at.Filename, at.ContentType, at.Disposition)
+		}
+
+		b, err := io.ReadAll(at.Data)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != td.data {
+			t.Errorf("[%d] Wrong attachment data. Expected: %s, Got: %s", i, td.data, string(b))
+		}
+	}
+}
+
+func TestParseSizeLimits(t *testing.T) {
+	var testData = []struct {
+		opts    Options
+		wantErr bool
+	}{
+		{opts: Options{}},
+		{opts: Options{MaxBodySize: 13, MaxAttachmentSize: 6}},
+		{opts: Options{MaxBodySize: 12}, wantErr: true},
+		{opts: Options{MaxAttachmentSize: 5}, wantErr: true},
+		{opts: Options{MaxAttachmentSize: 5, LazyAttachments: true}, wantErr: true},
+	}
+
+	for i, td := range testData {
+		e, err := ParseWithOptions(strings.NewReader(attachmentDispositionExample), td.opts)
+		if err == nil {
+			// lazily loaded attachments only hit the limit once they are read
+			for _, at := range e.Attachments {
+				if _, err = io.ReadAll(at.Data); err != nil {
+					break
+				}
+			}
+		}
+
+		if td.wantErr {
+			if !errors.Is(err, ErrSizeLimitExceeded) {
+				t.Errorf("[%d] Expected ErrSizeLimitExceeded, Got: %v", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("[%d] Unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestParsePartHeaders(t *testing.T) {
+	e, err := Parse(strings.NewReader(contentIDAttachmentExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.EmbeddedFiles) != 1 || len(e.Attachments) != 1 {
+		t.Fatalf("Incorrect number of parts! Expected: 1 embedded file and 1 attachment, Got: %v and %v.",
+			len(e.EmbeddedFiles), len(e.Attachments))
+	}
+
+	if got := e.EmbeddedFiles[0].Header.Get("Content-Id"); got != "<logo@example.com>" {
+		t.Errorf("Wrong embedded file Content-Id header. Expected: %s, Got: %s", "<logo@example.com>", got)
+	}
+
+	if got := e.Attachments[0].Header.Get("Content-Transfer-Encoding"); got != "base64" {
+		t.Errorf("Wrong attachment Content-Transfer-Encoding header. Expected: %s, Got: %s", "base64", got)
+	}
+
+	if got := e.Attachments[0].Header.Get("Content-Id"); got != "<chart@example.com>" {
+		t.Errorf("Wrong attachment Content-Id header. Expected: %s, Got: %s", "<chart@example.com>", got)
+	}
+}
+
+func TestAttachmentBytes(t *testing.T) {
+	e, err := Parse(strings.NewReader(contentIDAttachmentExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a partial read must not affect Bytes
+	io.ReadFull(e.Attachments[0].Data, make([]byte, 2))
+
+	for i := 0; i < 2; i++ {
+		b, err := e.Attachments[0].Bytes()
+		if err != nil {
+			t.Fatal(err)
+		} else if string(b) != "PNG" {
+			t.Errorf("[%d] Wrong attachment bytes. Expected: %s, Got: %s", i, "PNG", string(b))
+		}
+
+		b, err = e.EmbeddedFiles[0].Bytes()
+		if err != nil {
+			t.Fatal(err)
+		} else if string(b) != "GIF89a" {
+			t.Errorf("[%d] Wrong embedded file bytes. Expected: %s, Got: %s", i, "GIF89a", string(b))
+		}
+	}
+
+	b, err := io.ReadAll(e.Attachments[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(b) != "PNG" {
+		t.Errorf("Wrong attachment data after Bytes. Expected: %s, Got: %s", "PNG", string(b))
+	}
+}
+
+func TestParseAttachedMessage(t *testing.T) {
+	e, err := Parse(strings.NewReader(attachedMessageExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 2 {
+		t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", 2, len(e.Attachments))
+	}
+
+	msg := e.Attachments[0].Message
+	if msg == nil {
+		t.Fatal("Expected the message/rfc822 attachment to be parsed")
+	}
+
+	if msg.Subject != "Delivery report" || msg.TextBody != "The original message follows." {
+		t.Errorf("Wrong attached message. Got subject: %s, text body: %s", msg.Subject, msg.TextBody)
+	}
+
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Message == nil {
+		t.Fatal("Expected the message attached to the attached message to be parsed")
+	}
+
+	if got := msg.Attachments[0].Message.Subject; got != "Original" {
+		t.Errorf("Wrong nested message subject. Expected: %s, Got: %s", "Original", got)
+	}
+
+	if e.Attachments[1].Message != nil {
+		t.Error("Expected a malformed attached message to stay a plain attachment")
+	}
+
+	b, err := e.Attachments[1].Bytes()
+	if err != nil {
+		t.Fatal(err)
+	} else if string(b) != "not a message" {
+		t.Errorf("Wrong malformed attachment data. Expected: %q, Got: %q", "not a message", string(b))
+	}
+
+	// messages nested deeper than the limit are kept unparsed
+	nested := "From: a@example.com\nSubject: 0\n\nInnermost.\n"
+	for i := 1; i <= maxMessageNesting+1; i++ {
+		nested = fmt.Sprintf("From: a@example.com\nSubject: %d\nContent-Type: multipart/mixed; boundary=\"b%d\"\n\n--b%d\nContent-Type: message/rfc822\n\n%s--b%d--\n", i, i, i, nested, i)
+	}
+
+	e, err = Parse(strings.NewReader(nested))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	levels := 0
+	for m := &e; len(m.Attachments) == 1 && m.Attachments[0].Message != nil; m = m.Attachments[0].Message {
+		levels++
+	}
+
+	if levels != maxMessageNesting {
+		t.Errorf("Wrong number of parsed nested messages. Expected: %v, Got: %v", maxMessageNesting, levels)
+	}
+
+	// unwrapping stops at the same limit and reuses the attached messages
+	nested = "From: a@example.com\nDate: Mon, 1 Jan 2024 00:00:00 +0000\nSubject: 0\n\nInnermost.\n"
+	for i := 1; i <= 3*maxMessageNesting; i++ {
+		nested = fmt.Sprintf("From: a@example.com\nDate: Mon, 1 Jan 2024 00:00:00 +0000\nSubject: %d\nContent-Type: multipart/mixed; boundary=\"b%d\"\n\n--b%d\nContent-Type: message/rfc822\n\n%s--b%d--\n", i, i, i, nested, i)
+	}
+
+	e, err = ParseWithOptions(strings.NewReader(nested), Options{UnwrapNested: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	levels = 0
+	for m := &e; len(m.SubMessages) == 1; m = &m.SubMessages[0] {
+		if len(m.Attachments) != 1 || m.Attachments[0].Message == nil || m.Attachments[0].Message.Subject != m.SubMessages[0].Subject {
+			t.Fatalf("Expected the unwrapped message to be the attached message at level %v", levels)
+		}
+
+		levels++
+	}
+
+	if levels != maxMessageNesting {
+		t.Errorf("Wrong number of unwrapped nested messages. Expected: %v, Got: %v", maxMessageNesting, levels)
+	}
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	// nestedMultipart builds a message whose body is nested levels deep, alternating multipart/related and
+	// multipart/alternative
+	nestedMultipart := func(levels int) string {
+		kinds := []string{contentTypeMultipartRelated, contentTypeMultipartAlternative}
+
+		var b strings.Builder
+		b.WriteString("From: John Doe <jdoe@machine.example>\nSubject: Nested\nContent-Type: multipart/related; boundary=\"b0\"\n\n")
+		for i := 1; i < levels; i++ {
+			fmt.Fprintf(&b, "--b%d\nContent-Type: %s; boundary=\"b%d\"\n\n", i-1, kinds[i%2], i)
+		}
+
+		fmt.Fprintf(&b, "--b%d\nContent-Type: text/plain\n\nDeep.\n", levels-1)
+		for i := levels - 1; i >= 0; i-- {
+			fmt.Fprintf(&b, "--b%d--\n", i)
+		}
+
+		return b.String()
+	}
+
+	e, err := Parse(strings.NewReader(nestedMultipart(50)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != "Deep." {
+		t.Errorf("Wrong text body. Expected: %s, Got: %s", "Deep.", e.TextBody)
+	}
+
+	_, err = Parse(strings.NewReader(nestedMultipart(10000)))
+	if !errors.Is(err, ErrMaxDepthExceeded) {
+		t.Errorf("Expected ErrMaxDepthExceeded, Got: %v", err)
+	}
+
+	_, err = ParseWithOptions(strings.NewReader(nestedMultipart(5)), Options{MaxDepth: 4})
+	if !errors.Is(err, ErrMaxDepthExceeded) {
+		t.Errorf("Expected ErrMaxDepthExceeded with MaxDepth 4, Got: %v", err)
+	}
+
+	_, err = EstimateSize(strings.NewReader(nestedMultipart(10000)))
+	if !errors.Is(err, ErrMaxDepthExceeded) {
+		t.Errorf("Expected ErrMaxDepthExceeded from EstimateSize, Got: %v", err)
+	}
+}
+
+func TestParseRFC2231Filename(t *testing.T) {
+	e, err := Parse(strings.NewReader(rfc2231FilenameExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"€.pdf", "€ report.pdf", "café.pdf", "€ café.pdf", "café.pdf"}
+	if len(e.Attachments) != len(expected) {
+		t.Fatalf("Incorrect number of attachments! Expected: %v, Got: %v.", len(expected), len(e.Attachments))
+	}
+
+	for i, filename := range expected {
+		if e.Attachments[i].Filename != filename {
+			t.Errorf("[%d] Wrong filename. Expected: %s, Got: %s", i, filename, e.Attachments[i].Filename)
+		}
+	}
+}
+
+func TestParseBytesAndFile(t *testing.T) {
+	e, err := ParseBytes([]byte(data1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Subject != "Peter Paholík" {
+		t.Errorf("Wrong subject. Expected: %s, Got: %s", "Peter Paholík", e.Subject)
+	}
+
+	path := filepath.Join(t.TempDir(), "message.eml")
+	if err := os.WriteFile(path, []byte(data1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err = ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Subject != "Peter Paholík" {
+		t.Errorf("Wrong subject. Expected: %s, Got: %s", "Peter Paholík", e.Subject)
+	}
+
+	_, err = ParseFile(filepath.Join(t.TempDir(), "missing.eml"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, Got: %v", err)
+	}
+}
+
+func TestParseStructure(t *testing.T) {
+	e, err := Parse(strings.NewReader(data1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// describe renders the tree as nested content types
+	var describe func(p *Part) string
+	describe = func(p *Part) string {
+		children := []string{}
+		for _, child := range p.Children {
+			children = append(children, describe(child))
+		}
+
+		if len(children) == 0 {
+			return p.ContentType
+		}
+
+		return p.ContentType + "(" + strings.Join(children, " ") + ")"
+	}
+
+	expected := "multipart/mixed(multipart/alternative(text/plain text/html) application/json)"
+	if got := describe(e.Structure); got != expected {
+		t.Fatalf("Wrong structure. Expected: %s, Got: %s", expected, got)
+	}
+
+	json := e.Structure.Children[1]
+	if string(json.Content) != "[1, 2, 3]" {
+		t.Errorf("Wrong attachment part content. Expected: %s, Got: %s", "[1, 2, 3]", string(json.Content))
+	}
+
+	if got := json.Header.Get("X-Attachment-Id"); got != "f_j17i0f0d0" {
+		t.Errorf("Wrong attachment part header. Expected: %s, Got: %s", "f_j17i0f0d0", got)
+	}
+
+	if got := e.Structure.Header.Get("To"); got != "dusan@kasan.sk" {
+		t.Errorf("Wrong root part header. Expected: %s, Got: %s", "dusan@kasan.sk", got)
+	}
+
+	e, err = Parse(strings.NewReader(quotedPrintableExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Structure.ContentType != "text/plain" || len(e.Structure.Children) != 0 {
+		t.Errorf("Wrong structure of a single part message. Got: %s with %d children", e.Structure.ContentType, len(e.Structure.Children))
+	}
+
+	if !strings.HasPrefix(string(e.Structure.Content), "Hello wörld") {
+		t.Errorf("Wrong single part content. Got: %s", string(e.Structure.Content))
+	}
+
+	// repaired parts hold their decoded content as well
+	e, err = ParseWithOptions(strings.NewReader(missingContentTypeExample), Options{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []string{"See the attached report.", "%PDF-1.4"} {
+		if got := string(e.Structure.Children[i].Content); got != expected {
+			t.Errorf("Wrong content of part %d. Expected: %q, Got: %q", i+1, expected, got)
+		}
+	}
+}
+
+func TestPartWalk(t *testing.T) {
+	e, err := Parse(strings.NewReader(data1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testData = []struct {
+		stopAt   string
+		result   error
+		err      error
+		expected []string
+	}{
+		{
+			expected: []string{"multipart/mixed", "multipart/alternative", "text/plain", "text/html", "application/json"},
+		},
+		{
+			stopAt:   "multipart/alternative",
+			result:   SkipPart,
+			expected: []string{"multipart/mixed", "multipart/alternative", "application/json"},
+		},
+		{
+			stopAt:   "text/plain",
+			result:   SkipAll,
+			expected: []string{"multipart/mixed", "multipart/alternative", "text/plain"},
+		},
+		{
+			stopAt:   "text/html",
+			result:   io.ErrUnexpectedEOF,
+			err:      io.ErrUnexpectedEOF,
+			expected: []string{"multipart/mixed", "multipart/alternative", "text/plain", "text/html"},
+		},
+	}
+
+	for i, td := range testData {
+		visited := []string{}
+		err := e.WalkStructure(func(part *Part) error {
+			visited = append(visited, part.ContentType)
+			if part.ContentType == td.stopAt {
+				return td.result
+			}
+
+			return nil
+		})
+
+		if err != td.err {
+			t.Errorf("[%d] Wrong error. Expected: %v, Got: %v", i, td.err, err)
+		}
+
+		if strings.Join(visited, " ") != strings.Join(td.expected, " ") {
+			t.Errorf("[%d] Wrong parts visited. Expected: %v, Got: %v", i, td.expected, visited)
+		}
+	}
+
+	err = (&Email{}).WalkStructure(func(part *Part) error {
+		t.Errorf("Unexpected part visited: %v", part)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Unexpected error walking an email without structure: %v", err)
+	}
+}
+
+func TestParseMissingPartContentType(t *testing.T) {
+	for _, container := range []string{contentTypeMultipartAlternative, contentTypeMultipartRelated, contentTypeMultipartMixed} {
+		e, err := Parse(strings.NewReader(strings.Replace(missingPartContentTypeExample, "{{container}}", container, -1)))
+		if err != nil {
+			t.Errorf("[%s] Unexpected error: %v", container, err)
+			continue
+		}
+
+		if e.TextBody != "Plain text." {
+			t.Errorf("[%s] Wrong text body. Expected: %s, Got: %s", container, "Plain text.", e.TextBody)
+		}
+
+		if e.HTMLBody != "<p>Html.</p>" {
+			t.Errorf("[%s] Wrong html body. Expected: %s, Got: %s", container, "<p>Html.</p>", e.HTMLBody)
+		}
+	}
+}
+
+func TestParseInvalidPartContentType(t *testing.T) {
+	for _, container := range []string{contentTypeMultipartAlternative, contentTypeMultipartRelated, contentTypeMultipartMixed} {
+		message := strings.Replace(missingPartContentTypeExample, "{{container}}", container, -1)
+		message = strings.Replace(message, "Content-Transfer-Encoding: 7bit", "Content-Type: text/plain; charset", -1)
+
+		_, err := Parse(strings.NewReader(message))
+		if err == nil {
+			t.Errorf("[%s] Expected an error for a part with a malformed Content-Type", container)
+		}
+	}
+}
+
+func TestParseLenientSkipsBrokenParts(t *testing.T) {
+	_, err := Parse(strings.NewReader(brokenPartsExample))
+	if err == nil {
+		t.Error("Expected an error for broken parts without Options.Lenient")
+	}
+
+	e, err := ParseWithOptions(strings.NewReader(brokenPartsExample), Options{Lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != "Hello." {
+		t.Errorf("Wrong text body. Expected: %s, Got: %s", "Hello.", e.TextBody)
+	}
+
+	if e.HTMLBody != "<p>Hello.</p>" {
+		t.Errorf("Wrong html body. Expected: %s, Got: %s", "<p>Hello.</p>", e.HTMLBody)
+	}
+
+	if len(e.Attachments) != 1 || e.Attachments[0].Filename != "valid.txt" {
+		t.Fatalf("Expected only the valid attachment, Got: %v", e.Attachments)
+	}
+
+	if len(e.Warnings) != 2 {
+		t.Errorf("Incorrect number of warnings! Expected: %v, Got: %v (%v).", 2, len(e.Warnings), e.Warnings)
+	}
+
+	// the parts read before a broken multipart body are kept
+	truncated := strings.Replace(brokenPartsExample, "--outer\nContent-Type: text/html", "--outer\nContent-Type: text/html\nbroken header line", 1)
+	e, err = ParseWithOptions(strings.NewReader(truncated), Options{Lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != "Hello." || len(e.Attachments) != 1 {
+		t.Errorf("Expected the parts before the broken one to be kept, Got text body: %s, attachments: %v", e.TextBody, len(e.Attachments))
+	}
+
+	if len(e.Warnings) != 3 {
+		t.Errorf("Incorrect number of warnings! Expected: %v, Got: %v (%v).", 3, len(e.Warnings), e.Warnings)
+	}
+}
+
+func TestParseMessageIdLists(t *testing.T) {
+	var testData = []struct {
+		inReplyTo          string
+		references         string
+		expectedInReplyTo  []string
+		expectedReferences []string
+	}{
+		{},
+		{
+			inReplyTo:          "<1234@local.machine.example>",
+			references:         "<1111@local.machine.example>\r\n\t<2222@local.machine.example>\r\n <3333@local.machine.example>",
+			expectedInReplyTo:  []string{"1234@local.machine.example"},
+			expectedReferences: []string{"1111@local.machine.example", "2222@local.machine.example", "3333@local.machine.example"},
+		},
+		{
+			inReplyTo:          "Your message of \"Fri, 21 Nov 1997\" <1234@local.machine.example>",
+			references:         "<1111@local.machine.example><2222@local.machine.example>",
+			expectedInReplyTo:  []string{"1234@local.machine.example"},
+			expectedReferences: []string{"1111@local.machine.example", "2222@local.machine.example"},
+		},
+		{
+			references:         "1111@local.machine.example\t2222@local.machine.example",
+			expectedReferences: []string{"1111@local.machine.example", "2222@local.machine.example"},
+		},
+	}
+
+	for i, td := range testData {
+		message := "From: John Doe <jdoe@machine.example>\r\nSubject: Re: Thread\r\n"
+		if td.inReplyTo != "" {
+			message += "In-Reply-To: " + td.inReplyTo + "\r\n"
+		}
+
+		if td.references != "" {
+			message += "References: " + td.references + "\r\n"
+		}
+
+		e, err := Parse(strings.NewReader(message + "\r\nHello.\r\n"))
+		if err != nil {
+			t.Errorf("[%d] Unexpected error: %v", i, err)
+			continue
+		}
+
+		if strings.Join(e.InReplyTo, " ") != strings.Join(td.expectedInReplyTo, " ") || (td.expectedInReplyTo == nil) != (e.InReplyTo == nil) {
+			t.Errorf("[%d] Wrong In-Reply-To. Expected: %v, Got: %v", i, td.expectedInReplyTo, e.InReplyTo)
+		}
+
+		if strings.Join(e.References, " ") != strings.Join(td.expectedReferences, " ") || (td.expectedReferences == nil) != (e.References == nil) {
+			t.Errorf("[%d] Wrong References. Expected: %v, Got: %v", i, td.expectedReferences, e.References)
+		}
+	}
+}
+
+func TestParseDeliveryReport(t *testing.T) {
+	e, err := Parse(strings.NewReader(deliveryReportExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != "Your message could not be delivered to one or more recipients." {
+		t.Errorf("Wrong text body. Got: %s", e.TextBody)
+	}
+
+	report := e.Report
+	if report == nil {
+		t.Fatal("Expected a delivery report")
+	}
+
+	if report.ReportingMTA != "mx.example.net" {
+		t.Errorf("Wrong reporting MTA. Expected: %s, Got: %s", "mx.example.net", report.ReportingMTA)
+	}
+
+	if !report.ArrivalDate.Equal(parseDate("Fri, 21 Nov 1997 09:55:07 -0600")) {
+		t.Errorf("Wrong arrival date. Got: %v", report.ArrivalDate)
+	}
+
+	expected := []RecipientStatus{
+		{
+			FinalRecipient: "mary@example.net",
+			Action:         "failed",
+			Status:         "5.1.1",
+			DiagnosticCode: "550 5.1.1 <mary@example.net>: Recipient address rejected",
+			RemoteMTA:      "mail.example.net",
+		},
+		{
+			FinalRecipient:    "sales@example.net",
+			OriginalRecipient: "info@example.net",
+			Action:            "delayed",
+			Status:            "4.4.1",
+		},
+	}
+
+	if len(report.Recipients) != len(expected) {
+		t.Fatalf("Incorrect number of recipients! Expected: %v, Got: %v.", len(expected), len(report.Recipients))
+	}
+
+	for i, rs := range expected {
+		if report.Recipients[i] != rs {
+			t.Errorf("[%d] Wrong recipient status. Expected: %+v, Got: %+v", i, rs, report.Recipients[i])
+		}
+	}
+
+	if report.Original == nil || report.Original.Subject != "Meeting" {
+		t.Fatalf("Expected the original message to be returned, Got: %v", report.Original)
+	}
+
+	if report.OriginalHeader.Get("Message-ID") != "<1234@local.machine.example>" {
+		t.Errorf("Wrong original header. Got: %v", report.OriginalHeader)
+	}
+
+	if len(e.Attachments) != 1 || e.Attachments[0].Message != report.Original {
+		t.Errorf("Expected the original message to be an attachment too")
+	}
+
+	// only the headers of the original may be returned
+	headersOnly := strings.Replace(deliveryReportExample, "Content-Type: message/rfc822", "Content-Type: text/rfc822-headers", 1)
+	e, err = Parse(strings.NewReader(headersOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Report.Original != nil || e.Report.OriginalHeader.Get("Subject") != "Meeting" || len(e.Attachments) != 0 {
+		t.Errorf("Wrong report of returned headers. Got: %v, %v", e.Report.Original, e.Report.OriginalHeader)
+	}
+}
+
+func TestParseMultipartSigned(t *testing.T) {
+	message := strings.Replace(signedExample, "\n", "\r\n", -1)
+	e, err := Parse(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Signature == nil {
+		t.Fatal("Expected a signature")
+	}
+
+	if e.Signature.Protocol != "application/pgp-signature" || e.Signature.MICAlg != "pgp-sha256" {
+		t.Errorf("Wrong signature parameters. Got: %s %s", e.Signature.Protocol, e.Signature.MICAlg)
+	}
+
+	signed := "Content-Type: multipart/mixed; boundary=\"inner\"\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		"Signed text.\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Disposition: attachment; filename=\"notes.txt\"\r\n" +
+		"\r\n" +
+		"notes\r\n" +
+		"--inner--\r\n"
+	if string(e.Signature.SignedContent) != signed {
+		t.Errorf("Wrong signed content. Expected: %q, Got: %q", signed, string(e.Signature.SignedContent))
+	}
+
+	if !strings.HasPrefix(string(e.Signature.Data), "-----BEGIN PGP SIGNATURE-----") {
+		t.Errorf("Wrong signature data. Got: %q", string(e.Signature.Data))
+	}
+
+	if e.TextBody != "Signed text." {
+		t.Errorf("Wrong text body. Expected: %s, Got: %s", "Signed text.", e.TextBody)
+	}
+
+	if len(e.Attachments) != 1 || e.Attachments[0].Filename != "notes.txt" {
+		t.Errorf("Expected the attachment of the signed part, Got: %v", e.Attachments)
+	}
+}
+
+func TestParseMultipartEncrypted(t *testing.T) {
+	e, err := Parse(strings.NewReader(pgpEncryptedExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !e.IsEncrypted || e.Encrypted == nil {
+		t.Fatal("Expected an encrypted message")
+	}
+
+	if e.Encrypted.Protocol != "application/pgp-encrypted" {
+		t.Errorf("Wrong protocol. Expected: %s, Got: %s", "application/pgp-encrypted", e.Encrypted.Protocol)
+	}
+
+	if string(e.Encrypted.Control) != "Version: 1" {
+		t.Errorf("Wrong control part. Expected: %q, Got: %q", "Version: 1", string(e.Encrypted.Control))
+	}
+
+	expected := "-----BEGIN PGP MESSAGE-----\n\nhQEMA+bmHz7L\n-----END PGP MESSAGE-----"
+	if string(e.Encrypted.Data) != expected {
+		t.Errorf("Wrong encrypted data. Expected: %q, Got: %q", expected, string(e.Encrypted.Data))
+	}
+
+	if e.TextBody != "" || len(e.Attachments) != 0 {
+		t.Errorf("Expected the encrypted parts not to be parsed as bodies or attachments")
+	}
+}
+
+func TestParseMultipleBodies(t *testing.T) {
+	e, err := Parse(strings.NewReader(textBeforeAlternativeExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"Forwarding the note below.", "The note itself."}
+	if strings.Join(e.TextBodies, "|") != strings.Join(expected, "|") {
+		t.Errorf("Wrong text bodies. Expected: %q, Got: %q", expected, e.TextBodies)
+	}
+
+	if strings.Join(e.TextBodies, "") != e.TextBody {
+		t.Errorf("Expected TextBody to join the text bodies, Got: %q", e.TextBody)
+	}
+
+	if strings.Join(e.HTMLBodies, "") != e.HTMLBody || len(e.HTMLBodies) != 1 {
+		t.Errorf("Expected a single html body, Got: %q", e.HTMLBodies)
+	}
+
+	e, err = Parse(strings.NewReader(data1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.TextBodies) != 1 || len(e.HTMLBodies) != 1 {
+		t.Errorf("Expected one text and one html body, Got: %q, %q", e.TextBodies, e.HTMLBodies)
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	e, err := ParseContext(context.Background(), strings.NewReader(data1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Errorf("Incorrect number of attachments! Expected: %v, Got: %v.", 1, len(e.Attachments))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = ParseContext(ctx, strings.NewReader(data1))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, Got: %v", err)
+	}
+
+	// cancelling between parts aborts the parse even when the rest of the message is already buffered
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = ParseContext(ctx, io.TeeReader(strings.NewReader(data1), cancelWriter(cancel)))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled after the first read, Got: %v", err)
+	}
+}
+
+// cancelWriter calls cancel on its first write
+type cancelWriter context.CancelFunc
+
+func (cw cancelWriter) Write(b []byte) (int, error) {
+	cw()
+	return len(b), nil
+}
+
+// largeAttachmentsMessage builds a message with count base64 encoded attachments of size bytes each
+func largeAttachmentsMessage(count, size int) []byte {
+	var b bytes.Buffer
+	b.WriteString("From: John Doe <jdoe@machine.example>\r\nSubject: Large\r\nContent-Type: multipart/mixed; boundary=\"outer\"\r\n\r\n")
+	b.WriteString("--outer\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0xa5}, size))
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&b, "--outer\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"%d.bin\"\r\nContent-Transfer-Encoding: base64\r\n\r\n", i)
+		for start := 0; start < len(encoded); start += 76 {
+			end := start + 76
+			if end > len(encoded) {
+				end = len(encoded)
+			}
+
+			b.WriteString(encoded[start:end] + "\r\n")
+		}
+	}
+
+	b.WriteString("--outer--\r\n")
+
+	return b.Bytes()
+}
+
+func BenchmarkParseAttachments(b *testing.B) {
+	message := largeAttachmentsMessage(4, 1<<20)
+
+	for _, lazy := range []bool{false, true} {
+		b.Run(fmt.Sprintf("lazy=%v", lazy), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				e, err := ParseWithOptions(bytes.NewReader(message), Options{LazyAttachments: lazy})
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				// only the first attachment is needed
+				if _, err := io.Copy(io.Discard, e.Attachments[0].Data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestAttachmentByNameAndEmbeddedFileByCID(t *testing.T) {
+	e, err := Parse(strings.NewReader(contentIDAttachmentExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at, ok := e.AttachmentByName("CHART.png")
+	if !ok || at.Filename != "chart.png" {
+		t.Errorf("Expected to find chart.png, Got: %v %v", at.Filename, ok)
+	}
+
+	if at, ok := e.AttachmentByName("missing.png"); ok || at.Filename != "" || at.Data != nil {
+		t.Errorf("Expected no attachment, Got: %v", at.Filename)
+	}
+
+	for _, cid := range []string{"logo@example.com", "<logo@example.com>", "cid:logo@example.com"} {
+		ef, ok := e.EmbeddedFileByCID(cid)
+		if !ok || ef.CID != "logo@example.com" {
+			t.Errorf("[%s] Expected to find the embedded file, Got: %v %v", cid, ef.CID, ok)
+		}
+	}
+
+	// attachments with a Content-ID are not embedded files
+	if _, ok := e.EmbeddedFileByCID("chart@example.com"); ok {
+		t.Error("Expected no embedded file for the attachment's Content-ID")
+	}
+}
+
+func TestInlineImages(t *testing.T) {
+	e := Email{
+		EmbeddedFiles: []EmbeddedFile{
+			{CID: "logo@example.com", Filename: "logo.png"},
+			{CID: "chart@example.com", Filename: "chart.png"},
+			{CID: "logo@example.com", Filename: "duplicate.png"},
+			{Filename: "no-cid.png"},
+		},
+	}
+
+	images := e.InlineImages()
+	if len(images) != 2 {
+		t.Fatalf("Incorrect number of inline images! Expected: %v, Got: %v.", 2, len(images))
+	}
+
+	if images["logo@example.com"].Filename != "logo.png" {
+		t.Errorf("Expected the first file of a duplicate Content-ID, Got: %s", images["logo@example.com"].Filename)
+	}
+
+	if images["chart@example.com"].Filename != "chart.png" {
+		t.Errorf("Wrong file for chart@example.com. Got: %s", images["chart@example.com"].Filename)
+	}
+
+	parsed, err := Parse(strings.NewReader(contentIDAttachmentExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := parsed.InlineImages()["logo@example.com"]; !ok {
+		t.Error("Expected the parsed embedded image to be mapped by its Content-ID")
+	}
+}
+
+func TestFallbackFilename(t *testing.T) {
+	var testData = []struct {
+		contentType string
+		index       int
+		expected    string
+	}{
+		{contentType: "application/pdf", index: 3, expected: "attachment-3.pdf"},
+		{contentType: "IMAGE/JPEG", index: 1, expected: "attachment-1.jpg"},
+		{contentType: "message/rfc822", index: 2, expected: "attachment-2.eml"},
+		{contentType: "application/x-unknown-to-everyone", index: 4, expected: "attachment-4"},
+	}
+
+	for _, td := range testData {
+		if got := fallbackFilename(td.contentType, td.index); got != td.expected {
+			t.Errorf("[%s] Wrong fallback filename. Expected: %s, Got: %s", td.contentType, td.expected, got)
+		}
+	}
+}
+
+func TestParsePreambleEpilogue(t *testing.T) {
+	e, err := Parse(strings.NewReader(preambleEpilogueExample))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedText := "line one\n--outerish\n---outer\n -- outer\nlast line"
+	if e.TextBody != expectedText {
+		t.Errorf("Wrong text body. Expected: %q, Got: %q", expectedText, e.TextBody)
+	}
+
+	if e.HTMLBody != "<p>html</p>" {
+		t.Errorf("Wrong html body. Expected: %q, Got: %q", "<p>html</p>", e.HTMLBody)
+	}
+
+	for _, leaked := range []string{"Preamble", "Epilogue", "after the end"} {
+		if strings.Contains(e.TextBody, leaked) || strings.Contains(e.HTMLBody, leaked) {
+			t.Errorf("Body contains %q from outside the multipart body", leaked)
+		}
+	}
+
+	if len(e.Attachments) != 0 {
+		t.Errorf("Expected no attachments, got %d", len(e.Attachments))
+	}
+}
+
+func TestAttachmentContentTypeParams(t *testing.T) {
+	e, err := Parse(strings.NewReader(charsetAttachmentExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Expected 1 attachment, got %d", len(e.Attachments))
+	}
+
+	at := e.Attachments[0]
+	if at.ContentType != "text/plain" {
+		t.Errorf("Wrong content type. Expected: %s, Got: %s", "text/plain", at.ContentType)
+	}
+
+	if at.ContentTypeParams["charset"] != "iso-8859-2" {
+		t.Errorf("Wrong charset param. Expected: %s, Got: %s", "iso-8859-2", at.ContentTypeParams["charset"])
+	}
+
+	if at.ContentTypeParams["name"] != "notes.txt" {
+		t.Errorf("Wrong name param. Expected: %s, Got: %s", "notes.txt", at.ContentTypeParams["name"])
+	}
+
+	// the params survive a round trip through Bytes
+	b, err := e.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err = Parse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(e.Attachments) != 1 || e.Attachments[0].ContentTypeParams["charset"] != "iso-8859-2" {
+		t.Errorf("Charset param lost when writing the message. Got: %+v", e.Attachments)
+	}
+}
+
+func TestAttachmentChecksum(t *testing.T) {
+	sum := func(h hash.Hash, data string) string {
+		h.Write([]byte(data))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	e, err := Parse(strings.NewReader(contentIDAttachmentExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the checksum covers the decoded content, not the base64 text
+	if expected := sum(sha256.New(), "PNG"); e.Attachments[0].Checksum != expected {
+		t.Errorf("Wrong attachment checksum. Expected: %s, Got: %s", expected, e.Attachments[0].Checksum)
+	}
+
+	if expected := sum(sha256.New(), "GIF89a"); e.EmbeddedFiles[0].Checksum != expected {
+		t.Errorf("Wrong embedded file checksum. Expected: %s, Got: %s", expected, e.EmbeddedFiles[0].Checksum)
+	}
+
+	// computing the checksum must not consume the data
+	if b, err := io.ReadAll(e.Attachments[0].Data); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "PNG" {
+		t.Errorf("Wrong attachment data. Expected: %s, Got: %s", "PNG", string(b))
+	}
+
+	e, err = ParseWithOptions(strings.NewReader(contentIDAttachmentExample), Options{ChecksumHash: md5.New})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := sum(md5.New(), "PNG"); e.Attachments[0].Checksum != expected {
+		t.Errorf("Wrong md5 attachment checksum. Expected: %s, Got: %s", expected, e.Attachments[0].Checksum)
+	}
+}
+
+func TestParseBinaryEncoding(t *testing.T) {
+	data := "\x00\x01binary\x00\xff\xfe" + strings.Repeat("x", 1200) + "\x00"
+	msg := strings.Replace(binaryEncodingExample, "{{data}}", data, 1)
+
+	e, err := Parse(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if e.TextBody != "See the attached file." {
+		t.Errorf("Wrong text body. Expected: %q, Got: %q", "See the attached file.", e.TextBody)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Expected 1 attachment, got %d", len(e.Attachments))
+	}
+
+	b, err := e.Attachments[0].Bytes()
+	if err != nil {
+		t.Fatal(err)
+	} else if string(b) != data {
+		t.Errorf("Wrong attachment data. Expected: %q, Got: %q", data, string(b))
+	}
+}
+
+func TestParseReceived(t *testing.T) {
+	e, err := Parse(strings.NewReader(receivedExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ReceivedHeader{
+		{
+			From: "mail.example.org",
+			By:   "mx.example.net",
+			With: "ESMTPS",
+			ID:   "4F2A1B",
+			For:  "mary@example.net",
+			Date: parseDate("Fri, 21 Nov 1997 09:55:08 -0600"),
+		},
+		{
+			By:   "mail.example.org",
+			With: "ESMTPSA",
+			ID:   "abc123",
+			Date: parseDate("Fri, 21 Nov 1997 09:55:07 -0600"),
+		},
+		{
+			From: "garbage",
+		},
+	}
+
+	if len(e.Received) != len(expected) {
+		t.Fatalf("Wrong number of received headers. Expected: %d, Got: %d", len(expected), len(e.Received))
+	}
+
+	for i, rh := range e.Received {
+		if rh.Raw == "" {
+			t.Errorf("[%d] Raw received header is empty", i)
+		}
+
+		rh.Raw = ""
+		if rh != expected[i] {
+			t.Errorf("[%d] Wrong received header. Expected: %+v, Got: %+v", i, expected[i], rh)
+		}
+	}
+
+	e, err = Parse(strings.NewReader(rfc5322exampleA11))
+	if err != nil {
+		t.Fatal(err)
+	} else if e.Received != nil {
+		t.Errorf("Unexpected received headers: %+v", e.Received)
+	}
+}
+
+func TestParseKeepRaw(t *testing.T) {
+	// CRLF line endings and the epilogue must survive as they are
+	input := strings.Replace(preambleEpilogueExample, "\n", "\r\n", -1)
+
+	e, err := ParseWithOptions(strings.NewReader(input), Options{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(e.Raw) != input {
+		t.Errorf("Raw message differs from the input. Expected: %q, Got: %q", input, string(e.Raw))
+	}
+
+	c := e.Clone()
+	e.Raw[0] = 'X'
+	if string(c.Raw) != input {
+		t.Errorf("Clone shares the raw message")
+	}
+
+	e, err = Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	} else if e.Raw != nil {
+		t.Errorf("Raw message kept without KeepRaw")
+	}
+}
+
+func TestParseAttachedTextInAlternative(t *testing.T) {
+	for _, container := range []string{"multipart/alternative", "multipart/related"} {
+		e, err := Parse(strings.NewReader(strings.Replace(attachedTextExample, "{{container}}", container, 1)))
+		if err != nil {
+			t.Errorf("[%s] Unexpected error: %v", container, err)
+			continue
+		}
+
+		if e.TextBody != "Plain body" || e.HTMLBody != "<p>Html body</p>" {
+			t.Errorf("[%s] Attached files leaked into the bodies: %q %q", container, e.TextBody, e.HTMLBody)
+		}
+
+		expected := []attachmentData{
+			{filename: "log.txt", contentType: "text/plain", data: "log line"},
+			{filename: "page.html", contentType: "text/html", data: "<p>page</p>"},
+		}
+
+		if len(e.Attachments) != len(expected) {
+			t.Errorf("[%s] Wrong number of attachments. Expected: %d, Got: %d", container, len(expected), len(e.Attachments))
+			continue
+		}
+
+		for i, at := range e.Attachments {
+			b, err := at.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if at.Filename != expected[i].filename || at.ContentType != expected[i].contentType || string(b) != expected[i].data {
+				t.Errorf("[%s] Wrong attachment %d. Expected: %+v, Got: %s %s %q", container, i, expected[i], at.Filename, at.ContentType, b)
+			}
+		}
+	}
+}
+
+func TestParseUuencoded(t *testing.T) {
+	e, err := ParseWithOptions(strings.NewReader(uuencodedExample), Options{Uudecode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedText := "Hi,\nthe report follows.\n\n\nRegards,\nKari"
+	if e.TextBody != expectedText {
+		t.Errorf("Wrong text body. Expected: %q, Got: %q", expectedText, e.TextBody)
+	}
+
+	if len(e.Attachments) != 1 {
+		t.Fatalf("Expected 1 attachment, got %d", len(e.Attachments))
+	}
+
+	at := e.Attachments[0]
+	if at.Filename != "report.txt" || at.ContentType != "text/plain" {
+		t.Errorf("Wrong attachment. Expected: %s %s, Got: %s %s", "report.txt", "text/plain", at.Filename, at.ContentType)
+	}
+
+	expectedData := "Hello from a uuencoded file!\nSecond line with bytes \x00\xff.\n"
+	if b, err := at.Bytes(); err != nil {
+		t.Fatal(err)
+	} else if string(b) != expectedData {
+		t.Errorf("Wrong attachment data. Expected: %q, Got: %q", expectedData, string(b))
+	}
+
+	// without the option the block is left alone
+	e, err = Parse(strings.NewReader(uuencodedExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attachments) != 0 || !strings.Contains(e.TextBody, "begin 644 report.txt") {
+		t.Errorf("Uuencoded block was extracted without Options.Uudecode")
+	}
+}
+
+func TestParsePreserveTrailingNewline(t *testing.T) {
+	var testData = []struct {
+		message  string
+		opts     Options
+		expected string
+	}{
+		{
+			message:  "From: a@example.com\nContent-Type: text/plain\n\n+added line\n",
+			expected: "+added line",
+		},
+		{
+			message:  "From: a@example.com\nContent-Type: text/plain\n\n+added line\n",
+			opts:     Options{PreserveTrailingNewline: true},
+			expected: "+added line\n",
+		},
+		{
+			message:  "From: a@example.com\r\nContent-Type: text/plain\r\n\r\nline\r\n\r\n",
+			opts:     Options{PreserveTrailingNewline: true},
+			expected: "line\r\n\r\n",
+		},
+		{
+			// the line break preceding the boundary belongs to the boundary
+			message:  "From: a@example.com\nContent-Type: multipart/mixed; boundary=b\n\n--b\nContent-Type: text/plain\n\nline\n\n--b--\n",
+			opts:     Options{PreserveTrailingNewline: true},
+			expected: "line\n",
+		},
+	}
+
+	for index, td := range testData {
+		e, err := ParseWithOptions(strings.NewReader(td.message), td.opts)
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if e.TextBody != td.expected {
+			t.Errorf("[Test Case %v] Wrong text body. Expected: %q, Got: %q", index, td.expected, e.TextBody)
+		}
+	}
+}
+
+func TestParseNormalizeNewlines(t *testing.T) {
+	var testData = []struct {
+		message      string
+		expectedText string
+		expectedHTML string
+	}{
+		{
+			message:      "From: a@example.com\r\nContent-Type: text/plain\r\n\r\nline one\r\nline two\r\n",
+			expectedText: "line one\nline two",
+		},
+		{
+			message: "From: a@example.com\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/plain\r\n\r\nmixed\r\nendings\nhere\rtoo\r\n\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>\r\nhtml\r\n</p>\r\n" +
+				"--b--\r\n",
+			expectedText: "mixed\nendings\nhere\ntoo",
+			expectedHTML: "<p>\nhtml\n</p>",
+		},
+	}
+
+	for index, td := range testData {
+		e, err := ParseWithOptions(strings.NewReader(td.message), Options{NormalizeNewlines: true})
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if e.TextBody != td.expectedText {
+			t.Errorf("[Test Case %v] Wrong text body. Expected: %q, Got: %q", index, td.expectedText, e.TextBody)
+		}
+
+		if e.HTMLBody != td.expectedHTML {
+			t.Errorf("[Test Case %v] Wrong html body. Expected: %q, Got: %q", index, td.expectedHTML, e.HTMLBody)
+		}
+	}
+}
+
+func TestParseSniffContentType(t *testing.T) {
+	e, err := ParseWithOptions(strings.NewReader(octetStreamExample), Options{SniffContentType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.DetectedContentType != "application/pdf" {
+		t.Errorf("Wrong detected content type. Expected: %s, Got: %s", "application/pdf", e.DetectedContentType)
+	}
+
+	// sniffing must not consume the content
+	if b, err := io.ReadAll(e.Content); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(string(b), "%PDF-1.4") {
+		t.Errorf("Wrong content: %q", b)
+	}
+
+	e, err = Parse(strings.NewReader(octetStreamExample))
+	if err != nil {
+		t.Fatal(err)
+	} else if e.DetectedContentType != "" {
+		t.Errorf("Content type detected without SniffContentType: %s", e.DetectedContentType)
+	}
+}
+
+func TestParseMalformedBoundaryParam(t *testing.T) {
+	var testData = []struct {
+		contentType string
+		boundary    string
+	}{
+		{contentType: `multipart/mixed; boundary="----=_Part_0_12345";`, boundary: "----=_Part_0_12345"},
+		{contentType: `multipart/mixed; boundary=----=_Part_0_12345.67`, boundary: "----=_Part_0_12345.67"},
+		{contentType: `multipart/mixed; boundary==_abc?x@y`, boundary: "=_abc?x@y"},
+		{contentType: `multipart/mixed;; boundary="----=_Part_0_12345"`, boundary: "----=_Part_0_12345"},
+		{contentType: `multipart/mixed; boundary="----=_Part_0_12345`, boundary: "----=_Part_0_12345"},
+		{contentType: `multipart/mixed; boundary="b1" format`, boundary: "b1"},
+	}
+
+	for index, td := range testData {
+		message := "From: a@example.com\nContent-Type: " + td.contentType + "\n\n" +
+			"--" + td.boundary + "\nContent-Type: text/plain\n\nHello\n" +
+			"--" + td.boundary + "\nContent-Type: text/plain\nContent-Disposition: attachment; filename=a.txt\n\nfile\n" +
+			"--" + td.boundary + "--\n"
+
+		e, err := Parse(strings.NewReader(message))
+		if err != nil {
+			t.Errorf("[Test Case %v] %v", index, err)
+			continue
+		}
+
+		if e.TextBody != "Hello" || len(e.Attachments) != 1 {
+			t.Errorf("[Test Case %v] Wrong parts. Text body: %q, attachments: %d", index, e.TextBody, len(e.Attachments))
+		}
+	}
+}
+
+func TestParseStrictErrors(t *testing.T) {
+	crlf := func(s string) string {
+		return strings.Replace(s, "\n", "\r\n", -1)
+	}
+
+	var testData = []struct {
+		name     string
+		message  string
+		expected error
+	}{
+		{name: "conforming", message: crlf(strictExample)},
+		{name: "bare LF", message: strictExample, expected: ErrBareLineFeed},
+		{
+			name:     "bare LF in the epilogue",
+			message:  crlf(strictExample) + strings.Repeat("epilogue\r\n", 1000) + "bare\n",
+			expected: ErrBareLineFeed,
+		},
+		{
+			name:     "missing part content type",
+			message:  crlf(strings.Replace(strictExample, "Content-Type: text/plain; charset=utf-8\n", "", 1)),
+			expected: ErrMissingContentType,
+		},
+		{
+			name:     "invalid transfer encoding",
+			message:  crlf(strings.Replace(strictExample, "Content-Transfer-Encoding: 7bit", "Content-Transfer-Encoding: none", 1)),
+			expected: ErrInvalidTransferEncoding,
+		},
+		{
+			name:     "duplicate date",
+			message:  crlf("Date: Fri, 21 Nov 1997 09:55:07 -0600\n" + strictExample),
+			expected: ErrDuplicateHeader,
+		},
+		{
+			name:     "missing MIME version",
+			message:  crlf(strings.Replace(strictExample, "MIME-Version: 1.0\n", "", 1)),
+			expected: ErrMissingMIMEVersion,
+		},
+	}
+
+	for _, td := range testData {
+		// none of the checks fail the parse by default
+		if _, err := Parse(strings.NewReader(td.message)); err != nil {
+			t.Errorf("[%s] Unexpected error without strict errors: %v", td.name, err)
+		}
+
+		_, err := ParseWithOptions(strings.NewReader(td.message), Options{StrictErrors: true})
+		if td.expected == nil && err != nil {
+			t.Errorf("[%s] Unexpected error: %v", td.name, err)
+		} else if td.expected != nil && !errors.Is(err, td.expected) {
+			t.Errorf("[%s] Wrong error. Expected: %v, Got: %v", td.name, td.expected, err)
+		}
+	}
+
+	// lazily loaded attachments are read past during the parse, their line endings are checked too
+	message := strings.Replace(crlf(strictExample), "JVBERi0=", "JVBE\nRi0=", 1)
+	_, err := ParseWithOptions(strings.NewReader(message), Options{StrictErrors: true, LazyAttachments: true})
+	if !errors.Is(err, ErrBareLineFeed) {
+		t.Errorf("Wrong error for a lazy attachment. Expected: %v, Got: %v", ErrBareLineFeed, err)
+	}
+
+	_, err = ParseWithOptions(strings.NewReader(crlf(strictExample)), Options{StrictErrors: true, Lenient: true})
+	if !errors.Is(err, ErrConflictingOptions) {
+		t.Errorf("Wrong error for conflicting options. Expected: %v, Got: %v", ErrConflictingOptions, err)
+	}
+}
+
+func TestParsePartErrors(t *testing.T) {
+	var testData = []struct {
+		message  string
+		opts     Options
+		expected string
+		is       error
+	}{
+		{
+			message:  strings.Replace(partErrorsExample, "{{encoding}}", "x-unknown", 1),
+			expected: `part 2 (application/pdf "invoice.pdf"): unknown encoding: x-unknown`,
+		},
+		{
+			message: strings.Replace(strings.Replace(partErrorsExample, "{{encoding}}", "base64", 1),
+				"Content-Transfer-Encoding: 7bit", "Content-Transfer-Encoding: x-unknown", 1),
+			expected: `part 1 (multipart/alternative): part 2 (text/html): unknown encoding: x-unknown`,
+		},
+		{
+			message:  strings.Replace(partErrorsExample, "{{encoding}}", "base64", 1),
+			opts:     Options{MaxAttachmentSize: 2},
+			expected: `part 2 (application/pdf "invoice.pdf"): size limit exceeded`,
+			is:       ErrSizeLimitExceeded,
+		},
+	}
+
+	for index, td := range testData {
+		_, err := ParseWithOptions(strings.NewReader(td.message), td.opts)
+		if err == nil {
+			t.Errorf("[Test Case %v] Expected an error", index)
+			continue
+		}
+
+		if err.Error() != td.expected {
+			t.Errorf("[Test Case %v] Wrong error. Expected: %s, Got: %s", index, td.expected, err)
+		}
+
+		if td.is != nil && !errors.Is(err, td.is) {
+			t.Errorf("[Test Case %v] Error doesn't wrap %v: %v", index, td.is, err)
+		}
+	}
+}
+
+func parseDate(in string) time.Time {
+	out, err := time.Parse(time.RFC1123Z, in)
+	if err != nil {
+		panic(err)
+	}
+
+	return out
+}
+
+type attachmentData struct {
+	filename    string
+	contentType string
+	data        string
+}
+
+type embeddedFileData struct {
+	cid         string
+	contentType string
+	base64data  string
+}
+
+func assertSliceEq(a, b []string) bool {
+	if len(a) == len(b) && len(a) == 0 {
+		return true
+	}
+
+	if a == nil && b == nil {
+		return true
+	}
+
+	if a == nil || b == nil {
+		return false
+	}
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func assertAddressListEq(a, b []mail.Address) bool {
+	if len(a) == len(b) && len(a) == 0 {
+		return true
+	}
+
+	if a == nil && b == nil {
+		return true
+	}
+
+	if a == nil || b == nil {
+		return false
+	}
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func dereferenceAddressList(al []*mail.Address) (result []mail.Address) {
+	for _, a := range al {
+		result = append(result, *a)
+	}
+
+	return
+}
+
+var data1 = `From: =?UTF-8?Q?Peter_Pahol=C3=ADk?= <peter.paholik@gmail.com>
+Date: Fri, 7 Apr 2017 09:17:26 +0200
+Message-ID: <CACtgX4kNXE7T5XKSKeH_zEcfUUmf2vXVASxYjaaK9cCn-3zb_g@mail.gmail.com>
+Subject: =?UTF-8?Q?Peter_Pahol=C3=ADk?=
+To: dusan@kasan.sk
+Content-Type: multipart/mixed; boundary=f403045f1dcc043a44054c8e6bbf
+
+--f403045f1dcc043a44054c8e6bbf
+Content-Type: multipart/alternative; boundary=f403045f1dcc043a3f054c8e6bbd
+
+--f403045f1dcc043a3f054c8e6bbd
+Content-Type: text/plain; charset=UTF-8
+
+
+
+--f403045f1dcc043a3f054c8e6bbd
+Content-Type: text/html; charset=UTF-8
+
+<div dir="ltr"><br></div>
+
+--f403045f1dcc043a3f054c8e6bbd--
+--f403045f1dcc043a44054c8e6bbf
+Content-Type: application/json;
+	name="=?UTF-8?Q?Peter_Paholi=CC=81k_1?=
+	=?UTF-8?Q?_4_2017_2017=2D04=2D07=2Ejson?="
+Content-Disposition: attachment;
+	filename="=?UTF-8?Q?Peter_Paholi=CC=81k_1?=
+	=?UTF-8?Q?_4_2017_2017=2D04=2D07=2Ejson?="
+Content-Transfer-Encoding: base64
+X-Attachment-Id: f_j17i0f0d0
+
+WzEsIDIsIDNd
+--f403045f1dcc043a44054c8e6bbf--
+`
+
+var data2 = `Subject: Re: Test Subject 2
+To: info@receiver.com
+References: <2f6b7595-c01e-46e5-42bc-f263e1c4282d@receiver.com>
+ <9ff38d03-c4ab-89b7-9328-e99d5e24e3ba@domain.com>
+Cc: Cc Man <ccman@gmail.com>
+From: Sender Man <sender@domain.com>
+Message-ID: <0e9a21b4-01dc-e5c1-dcd6-58ce5aa61f4f@receiver.com>
+Date: Fri, 7 Apr 2017 12:59:55 +0200
+User-Agent: Mozilla/5.0 (Macintosh; Intel Mac OS X 10.12; rv:45.0)
+ Gecko/20100101 Thunderbird/45.8.0
+MIME-Version: 1.0
+In-Reply-To: <9ff38d03-c4ab-89b7-9328-e99d5e24e3ba@receiver.eu>
+Content-Type: multipart/alternative;
+ boundary="------------C70C0458A558E585ACB75FB4"
+
+This is a multi-part message in MIME format.
+--------------C70C0458A558E585ACB75FB4
+Content-Type: text/plain; charset=utf-8; format=flowed
+Content-Transfer-Encoding: 8bit
+
+First level
+> Second level
+>> Third level
+>
+
+
+--------------C70C0458A558E585ACB75FB4
+Content-Type: multipart/related;
+ boundary="------------5DB4A1356834BB602A5F88B2"
+
+
+--------------5DB4A1356834BB602A5F88B2
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: 8bit
+
+<html>data<img src="part2.9599C449.04E5EC81@develhell.com"/></html>
+
+--------------5DB4A1356834BB602A5F88B2
+Content-Type: image/png
+Content-Transfer-Encoding: base64
+Content-ID: <part2.9599C449.04E5EC81@develhell.com>
+
+iVBORw0KGgoAAAANSUhEUgAAAQEAAAAYCAIAAAB1IN9NAAAACXBIWXMAAAsTAAALEwEAmpwY
+YKUKF+Os3baUndC0pDnwNAmLy1SUr2Gw0luxQuV/AwC6cEhVV5VRrwAAAABJRU5ErkJggg==
+--------------5DB4A1356834BB602A5F88B2
+
+--------------C70C0458A558E585ACB75FB4--
+`
+
+var textPlainInMultipart = `From: Rares <rares@example.com>
+Date: Thu, 2 May 2019 11:25:35 +0300
+Subject: Re: kern/54143 (virtualbox)
+To: bugs@example.com
+Content-Type: multipart/mixed; boundary="0000000000007e2bb40587e36196"
+
+--0000000000007e2bb40587e36196
+Content-Type: text/plain; charset="UTF-8"
+
+plain text part
+--0000000000007e2bb40587e36196--
+`
+
+var textHTMLInMultipart = `From: Rares <rares@example.com>
+Date: Thu, 2 May 2019 11:25:35 +0300
+Subject: Re: kern/54143 (virtualbox)
+To: bugs@example.com
+Content-Type: multipart/mixed; boundary="0000000000007e2bb40587e36196"
+
+--0000000000007e2bb40587e36196
+Content-Type: text/html; charset="UTF-8"
+
+<div dir="ltr"><div>html text part</div><div><br></div><div><br><br></div></div>
+
+--0000000000007e2bb40587e36196--
+`
+
+var rfc5322exampleA11 = `From: John Doe <jdoe@machine.example>
+Sender: Michael Jones <mjones@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Saying Hello
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+
+This is a message just to say hello.
+So, "Hello".
+`
+
+var rfc5322exampleA12 = `From: "Joe Q. Public" <john.q.public@example.com>
+To: Mary Smith <mary@x.test>, jdoe@example.org, Who? <one@y.test>
+Cc: <boss@nil.test>, "Giant; \"Big\" Box" <sysservices@example.net>
+Date: Tue, 1 Jul 2003 10:52:37 +0200
+Message-ID: <5678.21-Nov-1997@example.com>
+
+Hi everyone.
+`
+
+var rfc5322exampleA12WithTimezone = `From: "Joe Q. Public" <john.q.public@example.com>
+To: Mary Smith <mary@x.test>, jdoe@example.org, Who? <one@y.test>
+Cc: <boss@nil.test>, "Giant; \"Big\" Box" <sysservices@example.net>
+Date: Tue, 1 Jul 2003 10:52:37 +0200 (GMT)
+Message-ID: <5678.21-Nov-1997@example.com>
+
+Hi everyone.
+`
+
+// we skipped the first message bcause it's the same as A 1.1
+var rfc5322exampleA2a = `From: Mary Smith <mary@example.net>
+To: John Doe <jdoe@machine.example>
+Reply-To: "Mary Smith: Personal Account" <smith@home.example>
+Subject: Re: Saying Hello
+Date: Fri, 21 Nov 1997 10:01:10 -0600
+Message-ID: <3456@example.net>
+In-Reply-To: <1234@local.machine.example>
+References: <1234@local.machine.example>
+
+This is a reply to your hello.
+`
+
+var rfc5322exampleA2b = `To: "Mary Smith: Personal Account" <smith@home.example>
+From: John Doe <jdoe@machine.example>
+Subject: Re: Saying Hello
+Date: Fri, 21 Nov 1997 11:00:00 -0600
+Message-ID: <abcd.1234@local.machine.test>
+In-Reply-To: <3456@example.net>
+References: <1234@local.machine.example> <3456@example.net>
+
+This is a reply to your reply.
+`
+
+var rfc5322exampleA3 = `Resent-From: Mary Smith <mary@example.net>
+Resent-To: Jane Brown <j-brown@other.example>
+Resent-Date: Mon, 24 Nov 1997 14:22:01 -0800
+Resent-Message-ID: <78910@example.net>
+From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Saying Hello
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+
+This is a message just to say hello.
+So, "Hello".`
+
+var imageContentExample = `From: John Doe <jdoe@machine.example>
+Sender: Michael Jones <mjones@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Saying Hello
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: image/jpeg;
+	x-unix-mode=0644;
+	name="image.gif"
+Content-Transfer-Encoding: base64
+
+R0lGODlhAQE7`
+
+var multipartRelatedExample = `MIME-Version: 1.0
+From: John Doe <jdoe@machine.example>
+Sender: Michael Jones <mjones@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Saying Hello
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Subject: ooops
+To: test@example.rocks
+Content-Type: multipart/related; boundary="000000000000ab2e2205a26de587"
+
+--000000000000ab2e2205a26de587
+Content-Type: multipart/alternative; boundary="000000000000ab2e1f05a26de586"
+
+--000000000000ab2e1f05a26de586
+Content-Type: text/plain; charset="UTF-8"
+
+Time for the egg.
+
+--000000000000ab2e1f05a26de586
+Content-Type: text/html; charset="UTF-8"
+
+<div dir="ltr"><div>Time for the egg.</div><div><br></div><div><br><br></div></div>
+
+--000000000000ab2e1f05a26de586--
+
+
+--000000000000ab2e2205a26de587--
+`
+var attachment7bit = `From: =?UTF-8?Q?Peter_Foobar?= <peter.foobar@gmail.com>
+Date: Tue, 2 Apr 2019 11:12:26 +0000
+Message-ID: <CACtgX4kNXE7T5XKSKeH_zEcfUUmf2vXVASxYjaaK9cCn-3zb_g@mail.gmail.com>
+Subject: =?UTF-8?Q?Peter_Foobar?=
+To: dusan@kasan.sk
+Content-Type: multipart/mixed; boundary=f403045f1dcc043a44054c8e6bbf
+
+--f403045f1dcc043a44054c8e6bbf
+Content-Type: multipart/alternative; boundary=f403045f1dcc043a3f054c8e6bbd
+
+--f403045f1dcc043a3f054c8e6bbd
+Content-Type: text/plain; charset=UTF-8
+
+
+
+--f403045f1dcc043a3f054c8e6bbd
+Content-Type: text/html; charset=UTF-8
+
+<div dir="ltr"><br></div>
+
+--f403045f1dcc043a3f054c8e6bbd--
+--f403045f1dcc043a44054c8e6bbf
+Content-Type: application/csv; 
+	name="unencoded.csv"
+Content-Transfer-Encoding: 7bit
+Content-Disposition: attachment; 
+	filename="unencoded.csv"
+
+
+"Some", "Data", "In", "Csv", "Format"
+"Foo", "Bar", "Baz", "Bum", "Poo"
+
+--f403045f1dcc043a44054c8e6bbf--
+`
+
+var smimeEnvelopedExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Encrypted
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name="smime.p7m"
+Content-Transfer-Encoding: base64
+Content-Disposition: attachment; filename="smime.p7m"
+
+ZW5jcnlwdGVkIHBheWxvYWQ=
+`
+
+var smimeSignedDataExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Signed
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: application/x-pkcs7-mime; smime-type=Signed-Data; name="smime.p7m"
+Content-Transfer-Encoding: base64
+
+c2lnbmVkIHBheWxvYWQ=
+`
+
+var multipleAttachmentsExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Invoice
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=UTF-8
+
+Please find the invoice attached.
+--inner
+Content-Type: text/html; charset=UTF-8
+
+<p>Please find the invoice attached.</p>
+--inner--
+--outer
+Content-Type: application/pdf; name="invoice.pdf"
+Content-Disposition: attachment; filename="invoice.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQ=
+--outer
+Content-Type: text/csv; name="data.csv"
+Content-Disposition: attachment; filename="data.csv"
+Content-Transfer-Encoding: base64
+
+YSxiLGM=
+--outer--
+`
+
+var gb2312SubjectExample = `From: =?GB2312?B?1tDOxA==?= <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: =?GB2312?B?1tDOxA==?=
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+
+Hello.
+`
+
+var latin1TextExample = "From: John Doe <jdoe@machine.example>\n" +
+	"To: Mary Smith <mary@example.net>\n" +
+	"Subject: Cafe\n" +
+	"Date: Fri, 21 Nov 1997 09:55:06 -0600\n" +
+	"Message-ID: <1234@local.machine.example>\n" +
+	"Content-Type: text/plain; charset=\"ISO-8859-1\"\n" +
+	"\n" +
+	"Caf\xe9\n"
+
+var identityEncodingExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Data
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=UTF-8
+
+See attached.
+--outer
+Content-Type: text/csv; name="data.csv"
+Content-Disposition: attachment; filename="data.csv"
+Content-Transfer-Encoding: {{encoding}}
+
+a,b,c
+
+--outer--
+`
+
+var inReplyToOnlyExample = `To: "Mary Smith: Personal Account" <smith@home.example>
+From: John Doe <jdoe@machine.example>
+Subject: Re: Saying Hello
+Date: Fri, 21 Nov 1997 11:00:00 -0600
+Message-ID: <abcd.1234@local.machine.test>
+In-Reply-To: <3456@example.net>
+
+This is a reply to your reply.
+`
+
+var quarantinedExample = `From: Mail Gateway <postmaster@example.com>
+To: Mary Smith <mary@example.net>
+Subject: [QUARANTINED] Cheap watches
+Date: Fri, 21 Nov 1997 10:00:00 -0600
+Message-ID: <quarantine.1234@example.com>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="quarantine"
+
+--quarantine
+Content-Type: text/plain; charset=UTF-8
+
+The attached message was quarantined by the mail gateway.
+--quarantine
+Content-Type: text/plain; name="original.txt"
+Content-Disposition: attachment; filename="original.txt"
+Content-Transfer-Encoding: 7bit
+
+Received: from mx.example.com by gateway.example.com
+From: Spammer <spam@example.org>
+To: Mary Smith <mary@example.net>
+Subject: Cheap watches
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <spam.1234@example.org>
+
+Buy now.
+
+--quarantine--
+`
+
+var mangledBoundaryExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Mangled
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: multipart/mixed; boundary="declared-boundary"
+
+--actual-boundary
+Content-Type: text/plain; charset=UTF-8
+
+Salvaged text.
+--actual-boundary
+Content-Type: text/csv; name="data.csv"
+Content-Disposition: attachment; filename="data.csv"
+Content-Transfer-Encoding: base64
+
+YSxiLGM=
+--actual-boundary--
+`
+
+var htmlMetaCharsetExample = "From: John Doe <jdoe@machine.example>\n" +
+	"To: Mary Smith <mary@example.net>\n" +
+	"Subject: Cafe\n" +
+	"Date: Fri, 21 Nov 1997 09:55:06 -0600\n" +
+	"Message-ID: <1234@local.machine.example>\n" +
+	"Content-Type: text/html\n" +
+	"\n" +
+	"<html><head>{{meta}}</head><body>Caf\xe9</body></html>\n"
+
+var dispositionExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Dispositions
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=UTF-8
+
+See attached.
+--outer
+Content-Type: text/csv; name="data.csv"
+Content-Disposition: ATTACHMENT; filename="data.csv"; size=5
+Content-Transfer-Encoding: base64
+
+YSxiLGM=
+--outer
+Content-Type: text/csv; name="form.csv"
+Content-Disposition: form-data; name="upload"; filename="form.csv"
+Content-Transfer-Encoding: base64
+
+YSxiLGM=
+--outer--
+`
+
+var recipientsExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>, Team: alice@example.net, Bob <bob@example.net>;
+Cc: MARY@example.net, undisclosed-recipients:;
+Bcc: Bob <Bob@Example.net>, carol@example.net
+Subject: Recipients
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+
+Hello.
+`
+
+var voicemailExample = `From: Voicemail <voicemail@example.com>
+To: Mary Smith <mary@example.net>
+Subject: New voicemail
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=UTF-8
+
+You have a new voicemail.
+--outer
+Content-Type: audio/wav; name="message.wav"
+Content-Disposition: attachment; filename="message.wav"
+Content-Transfer-Encoding: base64
+{{duration}}
+
+UklGRg==
+--outer--
+`
+
+var injectedBase64Example = "From: John Doe <jdoe@machine.example>\n" +
+	"To: Mary Smith <mary@example.net>\n" +
+	"Subject: Data\n" +
+	"Date: Fri, 21 Nov 1997 09:55:06 -0600\n" +
+	"Message-ID: <1234@local.machine.example>\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\n" +
+	"\n" +
+	"--outer\n" +
+	"Content-Type: application/json; name=\"data.json\"\n" +
+	"Content-Disposition: attachment; filename=\"data.json\"\n" +
+	"Content-Transfer-Encoding: base64\n" +
+	"\n" +
+	"WzEs IDIs\tID*Nd!\n" +
+	"--outer--\n"
+
+var appleDoubleExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Report
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=UTF-8
+
+See attached.
+--outer
+Content-Type: multipart/appledouble; boundary="apple"
+
+--apple
+Content-Type: application/applefile; name="report.pdf"
+Content-Transfer-Encoding: base64
+
+cmVzb3VyY2UgZm9yaw==
+--apple
+Content-Type: application/pdf; name="report.pdf"; x-mac-type="50444620"; x-mac-creator="4341524F"
+Content-Disposition: attachment; filename="report.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQ=
+--apple--
+--outer--
+`
+
+var contentIDOnlyExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Logo
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: {{container}}; boundary="outer"
+
+--outer
+Content-Type: text/html; charset=UTF-8
+
+<img src="cid:logo@example.com">
+--outer
+Content-Type: image/gif
+Content-Id: <logo@example.com>
+
+GIF89a
+--outer--
+`
+
+var contentIDAttachmentExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Chart
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/html; charset=UTF-8
+
+<img src="cid:logo@example.com"><img src="cid:chart@example.com">
+--outer
+Content-Type: image/gif
+Content-Id: <logo@example.com>
+
+GIF89a
+--outer
+Content-Type: image/png; name="chart.png"
+Content-Disposition: attachment; filename="chart.png"
+Content-Id: <chart@example.com>
+Content-Transfer-Encoding: base64
+
+UE5H
+--outer--
+`
+
+var qpBoundaryLineExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Fwd: Report
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="qp"
+
+--qp
+Content-Type: text/plain; charset=UTF-8
+Content-Transfer-Encoding: quoted-printable
+
+Forwarded message follows=
+{{line}}
+Content-Type: text/plain
+
+End of forwarded message
+--qp--
+`
+
+var missingContentTypeExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Report
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+
+See the attached report.
+--outer
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQ=
+--outer--
+`
+
+var listExample = `From: John Doe <jdoe@machine.example>
+To: golang-nuts@googlegroups.com
+Subject: Generics
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+List-Id: "Go Nuts" <golang-nuts.googlegroups.com>
+List-Post: <mailto:golang-nuts@googlegroups.com>, <https://groups.google.com/group/golang-nuts/post>
+List-Help: <mailto:golang-nuts+help@googlegroups.com?subject=help> (List Instructions),
+ <https://support.google.com/a/users/answer/9308991>
+List-Archive: <https://groups.google.com/group/golang-nuts>
+List-Subscribe: <mailto:golang-nuts+subscribe@googlegroups.com>
+List-Unsubscribe: <mailto:golang-nuts+unsubscribe@googlegroups.com>,
+ <https://groups.google.com/group/golang-nuts/unsubscribe?id=1>
+List-Unsubscribe-Post: List-Unsubscribe=One-Click
+List-Owner: <mailto:golang-nuts+owners@googlegroups.com>
+
+Hello
+`
+
+var bareAlternativeExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Invoice
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="alt"
+
+--alt
+Content-Type: text/plain; charset=UTF-8
+
+Please find the invoice attached.
+--alt
+Content-Type: text/html; charset=UTF-8
+
+<p>Please find the invoice attached.</p>
+--alt
+Content-Type: application/pdf; name="invoice.pdf"
+Content-Disposition: attachment; filename="invoice.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQ=
+--alt--
+`
+
+var forwardedWindows1252Example = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Fwd: Menu
+Date: Fri, 21 Nov 1997 10:01:10 -0600
+Message-ID: <5678@local.machine.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=UTF-8
+
+See the forwarded message.
+--outer
+Content-Type: message/rfc822; name="menu.eml"
+Content-Disposition: attachment; filename="menu.eml"
+Content-Transfer-Encoding: base64
+
+RnJvbTogSmFuZSBSb2UgPGphbmVAZXhhbXBsZS5jb20+DQpEYXRlOiBGcmksIDIxIE5vdiAxOTk3
+IDA5OjU1OjA2IC0wNjAwDQpTdWJqZWN0OiA9P3dpbmRvd3MtMTI1Mj9RP01lbj1GQz89DQpDb250
+ZW50LVR5cGU6IHRleHQvcGxhaW47IGNoYXJzZXQ9d2luZG93cy0xMjUyDQpDb250ZW50LVRyYW5z
+ZmVyLUVuY29kaW5nOiBxdW90ZWQtcHJpbnRhYmxlDQoNCkNhZj1FOSBjb3N0cyA9ODA1DQouLi4N
+Cg==
+--outer--
+`
+
+var quotedPrintableExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Encoded
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+Hello w=C3=B6rld, this line was wrapped with a soft =
+line break =3D done
+`
+
+var quotedPrintablePartsExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Encoded
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+Hello w=C3=B6rld, this line was wrapped with a soft =
+line break =3D done
+--outer
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+<p>Hello w=C3=B6rld, this line was wrapped with a soft =
+line break =3D done</p>
+--outer
+Content-Type: text/csv; name="data.csv"
+Content-Disposition: attachment; filename="data.csv"
+Content-Transfer-Encoding: quoted-printable
+
+a=3D1,b=3D=C3=A9
+
+--outer--
+`
+
+var textBeforeAlternativeExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Fwd: Note
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=UTF-8
+
+Forwarding the note below.
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=UTF-8
+
+The note itself.
+--inner
+Content-Type: text/html; charset=UTF-8
+
+<p>The note itself.</p>
+--inner--
+--outer--
+`
+
+var attachmentDispositionExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Dispositions
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=UTF-8
+
+See attached.
+--outer
+Content-Type: text/plain; charset=UTF-8
+Content-Disposition: attachment
+
+notes
+--outer
+Content-Type: image/gif
+Content-Disposition: inline; filename="logo.gif"
+
+GIF89a
+--outer--
+`
+
+var attachedMessageExample = `From: Mail Delivery System <mailer-daemon@example.net>
+To: John Doe <jdoe@machine.example>
+Subject: Undelivered Mail Returned to Sender
+Date: Fri, 21 Nov 1997 10:01:10 -0600
+Message-ID: <5678@example.net>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=UTF-8
+
+Your message could not be delivered.
+--outer
+Content-Type: message/rfc822
+
+From: Mail Delivery System <mailer-daemon@example.net>
+Subject: Delivery report
+Date: Fri, 21 Nov 1997 10:01:09 -0600
+Content-Type: multipart/mixed; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=UTF-8
+
+The original message follows.
+--inner
+Content-Type: message/rfc822
+
+From: John Doe <jdoe@machine.example>
+Subject: Original
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+
+Hello.
+--inner--
+
+--outer
+Content-Type: message/rfc822; name="broken.eml"
+Content-Disposition: attachment; filename="broken.eml"
+
+not a message
+--outer--
+`
+
+var rfc2231FilenameExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Filenames
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: application/pdf
+Content-Disposition: attachment; filename*=UTF-8''%E2%82%AC.pdf
+
+PDF
+--outer
+Content-Type: application/pdf
+Content-Disposition: attachment;
+ filename*0*=UTF-8''%E2%82%AC%20;
+ filename*1="report.pdf"
+
+PDF
+--outer
+Content-Type: application/pdf
+Content-Disposition: attachment; filename*=iso-8859-1''caf%E9.pdf
+
+PDF
+--outer
+Content-Type: application/pdf
+Content-Disposition: attachment;
+ filename*0*=windows-1252'en'%80%20;
+ filename*1="caf";
+ filename*2*=%E9.pdf
+
+PDF
+--outer
+Content-Type: application/pdf
+Content-Disposition: attachment; filename*0*=iso-8859-1''caf%E9; filename*1*=.pdf
+
+PDF
+--outer--
+`
+
+var missingPartContentTypeExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Defaults
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: {{container}}; boundary="outer"
+
+--outer
+Content-Transfer-Encoding: 7bit
+
+Plain text.
+--outer
+Content-Type: text/html; charset=UTF-8
+
+<p>Html.</p>
+--outer--
+`
+
+var brokenPartsExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Broken parts
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=UTF-8
+
+Hello.
+--outer
+Content-Type: application/octet-stream
+Content-Disposition: attachment; filename="encoded.bin"
+Content-Transfer-Encoding: x-uuencode
+
+begin 644 encoded.bin
+end
+--outer
+Content-Type: text/plain; charset
+Content-Disposition: attachment; filename="broken.txt"
+
+broken
+--outer
+Content-Type: text/plain
+Content-Disposition: attachment; filename="valid.txt"
+
+valid
+--outer
+Content-Type: text/html; charset=UTF-8
+
+<p>Hello.</p>
+--outer--
+`
+
+var deliveryReportExample = `From: Mail Delivery System <mailer-daemon@example.net>
+To: John Doe <jdoe@machine.example>
+Subject: Undelivered Mail Returned to Sender
+Date: Fri, 21 Nov 1997 10:01:10 -0600
+Message-ID: <5678@example.net>
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="report"
+
+--report
+Content-Type: text/plain; charset=UTF-8
 
-	if a == nil || b == nil {
-		return false
-	}
+Your message could not be delivered to one or more recipients.
+--report
+Content-Type: message/delivery-status
 
-	if len(a) != len(b) {
-		return false
-	}
+Reporting-MTA: dns; mx.example.net
+Arrival-Date: Fri, 21 Nov 1997 09:55:07 -0600
 
-	for i := range a {
-		if a[i] != b[i] {
-			return false
-		}
-	}
+Final-Recipient: rfc822; mary@example.net
+Action: failed
+Status: 5.1.1
+Remote-MTA: dns; mail.example.net
+Diagnostic-Code: smtp; 550 5.1.1 <mary@example.net>:
+ Recipient address rejected
 
-	return true
-}
+Final-Recipient: rfc822;sales@example.net
+Original-Recipient: rfc822;info@example.net
+Action: Delayed
+Status: 4.4.1 (no answer from host)
+--report
+Content-Type: message/rfc822
 
-func dereferenceAddressList(al []*mail.Address) (result []mail.Address) {
-	for _, a := range al {
-		result = append(result, *a)
-	}
+From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Meeting
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
 
-	return
-}
+See you tomorrow.
+--report--
+`
 
-var data1 = `From: =?UTF-8?Q?Peter_Pahol=C3=ADk?= <peter.paholik@gmail.com>
-Date: Fri, 7 Apr 2017 09:17:26 +0200
-Message-ID: <CACtgX4kNXE7T5XKSKeH_zEcfUUmf2vXVASxYjaaK9cCn-3zb_g@mail.gmail.com>
-Subject: =?UTF-8?Q?Peter_Pahol=C3=ADk?=
-To: dusan@kasan.sk
-Content-Type: multipart/mixed; boundary=f403045f1dcc043a44054c8e6bbf
+var signedExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Signed
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+MIME-Version: 1.0
+Content-Type: multipart/signed; micalg=pgp-sha256;
+ protocol="application/pgp-signature"; boundary="signed"
 
---f403045f1dcc043a44054c8e6bbf
-Content-Type: multipart/alternative; boundary=f403045f1dcc043a3f054c8e6bbd
+This is an OpenPGP/MIME signed message (RFC 4880 and 3156)
+--signed
+Content-Type: multipart/mixed; boundary="inner"
 
---f403045f1dcc043a3f054c8e6bbd
+--inner
 Content-Type: text/plain; charset=UTF-8
 
+Signed text.
+--inner
+Content-Type: text/plain
+Content-Disposition: attachment; filename="notes.txt"
 
+notes
+--inner--
 
---f403045f1dcc043a3f054c8e6bbd
-Content-Type: text/html; charset=UTF-8
+--signed
+Content-Type: application/pgp-signature; name="signature.asc"
+Content-Disposition: attachment; filename="signature.asc"
 
-<div dir="ltr"><br></div>
+-----BEGIN PGP SIGNATURE-----
 
---f403045f1dcc043a3f054c8e6bbd--
---f403045f1dcc043a44054c8e6bbf
-Content-Type: application/json;
-	name="=?UTF-8?Q?Peter_Paholi=CC=81k_1?=
-	=?UTF-8?Q?_4_2017_2017=2D04=2D07=2Ejson?="
-Content-Disposition: attachment;
-	filename="=?UTF-8?Q?Peter_Paholi=CC=81k_1?=
-	=?UTF-8?Q?_4_2017_2017=2D04=2D07=2Ejson?="
-Content-Transfer-Encoding: base64
-X-Attachment-Id: f_j17i0f0d0
+iQEzBAEBCAAdFiEE
+-----END PGP SIGNATURE-----
 
-WzEsIDIsIDNd
---f403045f1dcc043a44054c8e6bbf--
+--signed--
 `
 
-var data2 = `Subject: Re: Test Subject 2
-To: info@receiver.com
-References: <2f6b7595-c01e-46e5-42bc-f263e1c4282d@receiver.com>
- <9ff38d03-c4ab-89b7-9328-e99d5e24e3ba@domain.com>
-Cc: Cc Man <ccman@gmail.com>
-From: Sender Man <sender@domain.com>
-Message-ID: <0e9a21b4-01dc-e5c1-dcd6-58ce5aa61f4f@receiver.com>
-Date: Fri, 7 Apr 2017 12:59:55 +0200
-User-Agent: Mozilla/5.0 (Macintosh; Intel Mac OS X 10.12; rv:45.0)
- Gecko/20100101 Thunderbird/45.8.0
+var pgpEncryptedExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Encrypted
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
 MIME-Version: 1.0
-In-Reply-To: <9ff38d03-c4ab-89b7-9328-e99d5e24e3ba@receiver.eu>
-Content-Type: multipart/alternative;
- boundary="------------C70C0458A558E585ACB75FB4"
+Content-Type: multipart/encrypted; protocol="application/pgp-encrypted"; boundary="encrypted"
 
-This is a multi-part message in MIME format.
---------------C70C0458A558E585ACB75FB4
-Content-Type: text/plain; charset=utf-8; format=flowed
-Content-Transfer-Encoding: 8bit
+This is an OpenPGP/MIME encrypted message (RFC 4880 and 3156)
+--encrypted
+Content-Type: application/pgp-encrypted
+Content-Description: PGP/MIME version identification
 
-First level
-> Second level
->> Third level
->
+Version: 1
+--encrypted
+Content-Type: application/octet-stream; name="encrypted.asc"
+Content-Disposition: inline; filename="encrypted.asc"
 
+-----BEGIN PGP MESSAGE-----
 
---------------C70C0458A558E585ACB75FB4
-Content-Type: multipart/related;
- boundary="------------5DB4A1356834BB602A5F88B2"
+hQEMA+bmHz7L
+-----END PGP MESSAGE-----
+--encrypted--
+`
 
+var preambleEpilogueExample = `From: Kari Nordmann <kari@example.com>
+To: Ola Nordmann <ola@example.com>
+Subject: Preamble and epilogue
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
 
---------------5DB4A1356834BB602A5F88B2
-Content-Type: text/html; charset=utf-8
-Content-Transfer-Encoding: 8bit
+Preamble text for non-MIME readers.
+--outerish preamble line
+--outer-- not really the end
 
-<html>data<img src="part2.9599C449.04E5EC81@develhell.com"/></html>
+--outer
+Content-Type: text/plain; charset=utf-8
 
---------------5DB4A1356834BB602A5F88B2
-Content-Type: image/png
-Content-Transfer-Encoding: base64
-Content-ID: <part2.9599C449.04E5EC81@develhell.com>
+line one
+--outerish
+---outer
+ -- outer
+last line
+--outer
+Content-Type: text/html; charset=utf-8
 
-iVBORw0KGgoAAAANSUhEUgAAAQEAAAAYCAIAAAB1IN9NAAAACXBIWXMAAAsTAAALEwEAmpwY
-YKUKF+Os3baUndC0pDnwNAmLy1SUr2Gw0luxQuV/AwC6cEhVV5VRrwAAAABJRU5ErkJggg==
---------------5DB4A1356834BB602A5F88B2
+<p>html</p>
+--outer--
+Epilogue text.
+--outer
+Content-Type: text/plain
 
---------------C70C0458A558E585ACB75FB4--
+after the end
 `
 
-var textPlainInMultipart = `From: Rares <rares@example.com>
-Date: Thu, 2 May 2019 11:25:35 +0300
-Subject: Re: kern/54143 (virtualbox)
-To: bugs@example.com
-Content-Type: multipart/mixed; boundary="0000000000007e2bb40587e36196"
+var charsetAttachmentExample = `From: Kari Nordmann <kari@example.com>
+To: Ola Nordmann <ola@example.com>
+Subject: Notes
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="b1"
 
---0000000000007e2bb40587e36196
-Content-Type: text/plain; charset="UTF-8"
+--b1
+Content-Type: text/plain; charset=utf-8
 
-plain text part
---0000000000007e2bb40587e36196--
+See the attached notes.
+--b1
+Content-Type: text/plain; charset=iso-8859-2; name="notes.txt"
+Content-Disposition: attachment; filename="notes.txt"
+
+Notes
+--b1--
 `
 
-var textHTMLInMultipart = `From: Rares <rares@example.com>
-Date: Thu, 2 May 2019 11:25:35 +0300
-Subject: Re: kern/54143 (virtualbox)
-To: bugs@example.com
-Content-Type: multipart/mixed; boundary="0000000000007e2bb40587e36196"
+var binaryEncodingExample = `From: Kari Nordmann <kari@example.com>
+To: Ola Nordmann <ola@example.com>
+Subject: Binary
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="b1"
 
---0000000000007e2bb40587e36196
-Content-Type: text/html; charset="UTF-8"
+--b1
+Content-Type: text/plain
+Content-Transfer-Encoding: binary
 
-<div dir="ltr"><div>html text part</div><div><br></div><div><br><br></div></div>
+See the attached file.
+--b1
+Content-Type: application/octet-stream
+Content-Disposition: attachment; filename="data.bin"
+Content-Transfer-Encoding: binary
 
---0000000000007e2bb40587e36196--
+{{data}}
+--b1--
 `
 
-var rfc5322exampleA11 = `From: John Doe <jdoe@machine.example>
-Sender: Michael Jones <mjones@machine.example>
+var receivedExample = `Received: from mail.example.org (mail.example.org [192.0.2.1])
+	by mx.example.net (Postfix) with ESMTPS id 4F2A1B
+	for <mary@example.net>; Fri, 21 Nov 1997 09:55:08 -0600
+Received: by mail.example.org (Postfix, from userid 1000) with ESMTPSA id abc123;
+	Fri, 21 Nov 1997 09:55:07 -0600
+Received: from garbage
+From: John Doe <jdoe@example.org>
 To: Mary Smith <mary@example.net>
-Subject: Saying Hello
+Subject: Hops
 Date: Fri, 21 Nov 1997 09:55:06 -0600
-Message-ID: <1234@local.machine.example>
 
-This is a message just to say hello.
-So, "Hello".
+Hello.
 `
 
-var rfc5322exampleA12 = `From: "Joe Q. Public" <john.q.public@example.com>
-To: Mary Smith <mary@x.test>, jdoe@example.org, Who? <one@y.test>
-Cc: <boss@nil.test>, "Giant; \"Big\" Box" <sysservices@example.net>
-Date: Tue, 1 Jul 2003 10:52:37 +0200
-Message-ID: <5678.21-Nov-1997@example.com>
+var attachedTextExample = `From: Kari Nordmann <kari@example.com>
+To: Ola Nordmann <ola@example.com>
+Subject: Attached text
+MIME-Version: 1.0
+Content-Type: {{container}}; boundary="b1"
 
-Hi everyone.
+--b1
+Content-Type: text/plain
+
+Plain body
+--b1
+Content-Type: text/plain
+Content-Disposition: attachment; filename="log.txt"
+
+log line
+--b1
+Content-Type: text/html
+
+<p>Html body</p>
+--b1
+Content-Type: text/html; name="page.html"
+Content-Disposition: attachment; filename="page.html"
+
+<p>page</p>
+--b1--
 `
 
-var rfc5322exampleA12WithTimezone = `From: "Joe Q. Public" <john.q.public@example.com>
-To: Mary Smith <mary@x.test>, jdoe@example.org, Who? <one@y.test>
-Cc: <boss@nil.test>, "Giant; \"Big\" Box" <sysservices@example.net>
-Date: Tue, 1 Jul 2003 10:52:37 +0200 (GMT)
-Message-ID: <5678.21-Nov-1997@example.com>
+var uuencodedExample = "From: Kari Nordmann <kari@example.com>\n" +
+	"To: Ola Nordmann <ola@example.com>\n" +
+	"Subject: Report\n" +
+	"\n" +
+	"Hi,\n" +
+	"the report follows.\n" +
+	"\n" +
+	"begin 644 report.txt\n" +
+	"M2&5L;&\\@9G)O;2!A('5U96YC;V1E9\"!F:6QE(0I396-O;F0@;&EN92!W:71H\n" +
+	"+(&)Y=&5S(`#_+@H`\n" +
+	"`\n" +
+	"end\n" +
+	"\n" +
+	"Regards,\n" +
+	"Kari\n"
 
-Hi everyone.
+var octetStreamExample = `From: Kari Nordmann <kari@example.com>
+To: Ola Nordmann <ola@example.com>
+Subject: Scan
+MIME-Version: 1.0
+Content-Type: application/octet-stream
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQKJeLjz9MKMSAwIG9iago=
 `
 
-// we skipped the first message bcause it's the same as A 1.1
-var rfc5322exampleA2a = `From: Mary Smith <mary@example.net>
-To: John Doe <jdoe@machine.example>
-Reply-To: "Mary Smith: Personal Account" <smith@home.example>
-Subject: Re: Saying Hello
-Date: Fri, 21 Nov 1997 10:01:10 -0600
-Message-ID: <3456@example.net>
-In-Reply-To: <1234@local.machine.example>
-References: <1234@local.machine.example>
+var strictExample = `From: Kari Nordmann <kari@example.com>
+To: Ola Nordmann <ola@example.com>
+Subject: Strict
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@example.com>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="b1"
 
-This is a reply to your hello.
+--b1
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 7bit
+
+Hello
+--b1
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="a.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0=
+--b1--
 `
 
-var rfc5322exampleA2b = `To: "Mary Smith: Personal Account" <smith@home.example>
-From: John Doe <jdoe@machine.example>
-Subject: Re: Saying Hello
-Date: Fri, 21 Nov 1997 11:00:00 -0600
-Message-ID: <abcd.1234@local.machine.test>
-In-Reply-To: <3456@example.net>
-References: <1234@local.machine.example> <3456@example.net>
+var partErrorsExample = `From: Kari Nordmann <kari@example.com>
+To: Ola Nordmann <ola@example.com>
+Subject: Invoice
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
 
-This is a reply to your reply.
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain
+
+Invoice attached.
+--inner
+Content-Type: text/html
+Content-Transfer-Encoding: 7bit
+
+<p>Invoice attached.</p>
+--inner--
+--outer
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="invoice.pdf"
+Content-Transfer-Encoding: {{encoding}}
+
+JVBERi0=
+--outer--
 `
 
-var rfc5322exampleA3 = `Resent-From: Mary Smith <mary@example.net>
-Resent-To: Jane Brown <j-brown@other.example>
-Resent-Date: Mon, 24 Nov 1997 14:22:01 -0800
-Resent-Message-ID: <78910@example.net>
+var deliveredToExample = `Return-Path: <bounces@example.org>
+Delivered-To: mary@example.net
+X-Original-To: info@example.net
+Delivered-To: sales@example.net
 From: John Doe <jdoe@machine.example>
-To: Mary Smith <mary@example.net>
-Subject: Saying Hello
+To: Sales <sales@example.net>
+Subject: Alias
 Date: Fri, 21 Nov 1997 09:55:06 -0600
 Message-ID: <1234@local.machine.example>
 
-This is a message just to say hello.
-So, "Hello".`
+Hello.
+`
 
-var imageContentExample = `From: John Doe <jdoe@machine.example>
-Sender: Michael Jones <mjones@machine.example>
+var encodedTextPartsExample = `From: John Doe <jdoe@machine.example>
 To: Mary Smith <mary@example.net>
-Subject: Saying Hello
+Subject: Encoded
 Date: Fri, 21 Nov 1997 09:55:06 -0600
 Message-ID: <1234@local.machine.example>
-Content-Type: image/jpeg;
-	x-unix-mode=0644;
-	name="image.gif"
+Content-Type: {{container}}; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=utf-8
 Content-Transfer-Encoding: base64
 
-R0lGODlhAQE7`
+SGVsbG8gd8O2cmxk
+--outer
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: base64
 
-var multipartRelatedExample = `MIME-Version: 1.0
-From: John Doe <jdoe@machine.example>
-Sender: Michael Jones <mjones@machine.example>
+PHA+SGVsbG8gd8O2cmxkPC9wPg==
+--outer--
+`
+
+var encodedTextExample = `From: John Doe <jdoe@machine.example>
 To: Mary Smith <mary@example.net>
-Subject: Saying Hello
+Subject: Encoded
 Date: Fri, 21 Nov 1997 09:55:06 -0600
 Message-ID: <1234@local.machine.example>
-Subject: ooops
-To: test@example.rocks
-Content-Type: multipart/related; boundary="000000000000ab2e2205a26de587"
-
---000000000000ab2e2205a26de587
-Content-Type: multipart/alternative; boundary="000000000000ab2e1f05a26de586"
-
---000000000000ab2e1f05a26de586
-Content-Type: text/plain; charset="UTF-8"
-
-Time for the egg.
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: base64
 
---000000000000ab2e1f05a26de586
-Content-Type: text/html; charset="UTF-8"
+SGVsbG8gd8O2cmxk
+`
 
-<div dir="ltr"><div>Time for the egg.</div><div><br></div><div><br><br></div></div>
+var boundaryTrailingSpaceExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Boundary
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: multipart/alternative; boundary="simple boundary "
 
---000000000000ab2e1f05a26de586--
+--simple boundary
+Content-Type: text/plain; charset=utf-8
 
+Hello.
+--simple boundary
+Content-Type: text/html; charset=utf-8
 
---000000000000ab2e2205a26de587--
+<p>Hello.</p>
+--simple boundary--
 `
-var attachment7bit = `From: =?UTF-8?Q?Peter_Foobar?= <peter.foobar@gmail.com>
-Date: Tue, 2 Apr 2019 11:12:26 +0000
-Message-ID: <CACtgX4kNXE7T5XKSKeH_zEcfUUmf2vXVASxYjaaK9cCn-3zb_g@mail.gmail.com>
-Subject: =?UTF-8?Q?Peter_Foobar?=
-To: dusan@kasan.sk
-Content-Type: multipart/mixed; boundary=f403045f1dcc043a44054c8e6bbf
 
---f403045f1dcc043a44054c8e6bbf
-Content-Type: multipart/alternative; boundary=f403045f1dcc043a3f054c8e6bbd
+var missingClosingBoundaryExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Truncated
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: multipart/mixed; boundary="outer"
 
---f403045f1dcc043a3f054c8e6bbd
+--outer
 Content-Type: text/plain; charset=UTF-8
 
+See attached.
+--outer
+Content-Type: text/csv; name="data.csv"
+Content-Disposition: attachment; filename="data.csv"
+Content-Transfer-Encoding: base64
 
+YSxiLGM=
+`
 
---f403045f1dcc043a3f054c8e6bbd
-Content-Type: text/html; charset=UTF-8
+var vcardExample = `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Contacts
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <1234@local.machine.example>
+Content-Type: multipart/mixed; boundary="outer"
 
-<div dir="ltr"><br></div>
+--outer
+Content-Type: text/plain; charset=UTF-8
 
---f403045f1dcc043a3f054c8e6bbd--
---f403045f1dcc043a44054c8e6bbf
-Content-Type: application/csv; 
-	name="unencoded.csv"
-Content-Transfer-Encoding: 7bit
-Content-Disposition: attachment; 
-	filename="unencoded.csv"
+Contacts attached.
+--outer
+Content-Type: text/vcard; name="jane.vcf"
+Content-Disposition: attachment; filename="jane.vcf"
 
+BEGIN:VCARD
+VERSION:3.0
+N:Doe;Jane;;;
+FN:Jane
+  Doe
+EMAIL;TYPE=INTERNET:jane@example.com
+item1.EMAIL;TYPE=WORK:jane.doe@work.example.com
+END:VCARD
 
-"Some", "Data", "In", "Csv", "Format"
-"Foo", "Bar", "Baz", "Bum", "Poo"
+--outer
+Content-Type: text/x-vcard
+Content-Transfer-Encoding: base64
 
---f403045f1dcc043a44054c8e6bbf--
+QkVHSU46VkNBUkQKRk46Sm9obiBTbWl0aApFTUFJTDpqb2huQGV4YW1wbGUuY29tCkVORDpWQ0FSRAo=
+--outer--
 `